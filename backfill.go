@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// backfillWindow limits a backfill to what was updated, or for workflow runs created, between since and until
+type backfillWindow struct {
+	since time.Time
+	until time.Time
+}
+
+// backfillStep is the page a step of a repository backfill continues from after a rate limit
+type backfillStep struct {
+	page string
+	done bool
+}
+
+// backfillProgress keeps the steps of one repository by name
+type backfillProgress map[string]*backfillStep
+
+// run does a step from the page it reached, starting at first. Steps that completed are not run again
+func (p backfillProgress) run(name string, first string, backfill func(page *string) error) error {
+	step, found := p[name]
+	if !found {
+		step = &backfillStep{page: first}
+		p[name] = step
+	}
+	if step.done {
+		return nil
+	}
+	if err := backfill(&step.page); err != nil {
+		return err
+	}
+	step.done = true
+	return nil
+}
+
+// parseEvents splits a comma separated list of event types, ignoring spaces and empty entries
+func parseEvents(value string) []string {
+	events := []string{}
+	for _, event := range strings.Split(value, ",") {
+		if event = strings.TrimSpace(event); event != "" {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func parseBackfillTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
+
+// runBackfill is the backfill command, indexing history outside the crawl window. Document ids are the
+// same as when crawling so running it again only gives conflicts
+func runBackfill(args []string) {
+	flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+	var repositories stringList
+	flags.Var(&repositories, "repo", "Repository owner/name to backfill, can be given more than once. All repositories when not given")
+	sinceValue := flags.String("since", "", "Start of the range, 2006-01-02 or RFC3339 (required)")
+	untilValue := flags.String("until", "", "End of the range, 2006-01-02 or RFC3339, defaults to now")
+	events := flags.String("events", "", "Comma separated event types, defaults to github.crawl_events")
+	flags.Parse(args)
+	window := backfillWindow{until: time.Now()}
+	var err error
+	if *sinceValue == "" {
+		logger.Error("backfill needs --since")
+		os.Exit(1)
+	}
+	if window.since, err = parseBackfillTime(*sinceValue); err != nil {
+		logger.Error("error parsing --since", "error", err)
+		os.Exit(1)
+	}
+	if *untilValue != "" {
+		if window.until, err = parseBackfillTime(*untilValue); err != nil {
+			logger.Error("error parsing --until", "error", err)
+			os.Exit(1)
+		}
+	}
+	githubConfig := config.Github
+	if *events != "" {
+		githubConfig.CrawlEvents = parseEvents(*events)
+	}
+	filter, err := NewRepositoryFilter(githubConfig.Repositories)
+	if err != nil {
+		logger.Error("error in github.repositories", "error", err)
+		os.Exit(1)
+	}
+	sink := initSinks(config)
+	crawler := &Crawler{Config: githubConfig, Sink: sink, Filter: filter}
+	done, failed := crawler.backfill(repositories, window)
+	rejected, err := flushBackfill(sink)
+	if err != nil {
+		logger.Error("error flushing documents", "error", err)
+		os.Exit(1)
+	}
+	for _, name := range rejected {
+		logger.Error("documents rejected after queuing, run the backfill again", "repository", name)
+	}
+	failed += len(rejected)
+	fmt.Printf("repositories: %v, failed: %v\n", done, failed)
+	if len(rejected) > 0 {
+		fmt.Printf("rejected: %v\n", strings.Join(rejected, ","))
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// flushBackfill closes the sink and returns the repositories with documents rejected after they were queued
+func flushBackfill(sink Sink) ([]string, error) {
+	err := sink.Close()
+	var rejected []string
+	if reporter, ok := sink.(failureReporter); ok {
+		rejected = reporter.failedRepositories()
+	}
+	return rejected, err
+}
+
+// backfill crawls the named repositories, or all listed and filtered ones, returning how many were done and failed
+func (c *Crawler) backfill(names []string, window backfillWindow) (int, int) {
+	var list []Repository
+	if len(names) == 0 {
+		repositories, err := c.ListRepositories()
+		if err != nil {
+			logger.Error("error listing repositories", "error", err)
+			return 0, 1
+		}
+		list = c.Filter.apply(repositories)
+	}
+	failed := 0
+	for _, name := range names {
+		repository := Repository{}
+		_, err := c.client().get(c.client().url("/repos/%v", name), &repository)
+		if err != nil {
+			logger.Error("error getting repository", "repository", name, "error", err)
+			failed += 1
+			continue
+		}
+		list = append(list, repository)
+	}
+	done := 0
+	for _, repository := range list {
+		logger.Info("Backfilling", "repository", repository.FullName, "since", window.since, "until", window.until)
+		progress := backfillProgress{}
+		err := c.backfillRepository(repository, window, progress)
+		for errors.Is(err, ErrRateLimited) {
+			until := c.client().limits.blockedUntil()
+			logger.Info("Rate limited, waiting", "until", until)
+			time.Sleep(time.Until(until))
+			err = c.backfillRepository(repository, window, progress)
+		}
+		if err != nil {
+			logger.Error("error backfilling", "repository", repository.FullName, "error", err)
+			failed += 1
+			continue
+		}
+		done += 1
+	}
+	return done, failed
+}
+
+// backfillRepository does the configured steps of one repository. After a rate limit it can be called again
+// with the same progress to continue from the page each step reached
+func (c *Crawler) backfillRepository(repository Repository, window backfillWindow, progress backfillProgress) error {
+	if c.Config.crawlEvent("pull_request") || c.Config.crawlEvent("pull_request_review") {
+		first := c.client().url("/repos/%v/pulls?state=all&sort=updated&direction=desc&per_page=100", repository.FullName)
+		err := progress.run("pull_requests", first, func(page *string) error {
+			return c.backfillPullRequests(repository, window, page)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if c.Config.crawlEvent("issues") && repository.HasIssues {
+		first := c.client().url("/repos/%v/issues?state=all&sort=updated&direction=desc&per_page=100&since=%v", repository.FullName, window.since.UTC().Format(time.RFC3339))
+		err := progress.run("issues", first, func(page *string) error {
+			return c.backfillIssues(repository, window, page)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if c.Config.crawlEvent("workflow_run") || c.Config.crawlEvent("workflow_job") {
+		created := fmt.Sprintf("%v..%v", window.since.UTC().Format(time.RFC3339), window.until.UTC().Format(time.RFC3339))
+		first := c.client().url("/repos/%v/actions/runs?created=%v&per_page=100", repository.FullName, created)
+		err := progress.run("workflow_runs", first, func(page *string) error {
+			return c.crawlWorkflowRunPages(repository, page)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillPullRequests indexes the pull requests, and their reviews, updated in the window from page on. Stops
+// at a rate limit with page left where to continue, other errors are returned once the window is done
+func (c *Crawler) backfillPullRequests(repository Repository, window backfillWindow, page *string) error {
+	var pushErr error
+	idx := 0
+	for *page != "" {
+		pulls, nextURL, err := c.getPullRequestsPage(*page)
+		if err != nil && err != ErrNotModified {
+			return err
+		}
+		for _, pull := range pulls {
+			if pull.UpdatedAt.After(window.until) {
+				continue
+			}
+			if pull.UpdatedAt.Before(window.since) {
+				return pushErr
+			}
+			if c.Config.crawlEvent("pull_request") {
+				if err := c.indexPullRequest(repository, idx, pull, time.Since(pull.CreatedAt)); err != nil {
+					pushErr = err
+				}
+			}
+			if c.Config.crawlEvent("pull_request_review") {
+				if err := c.crawlReviews(repository, pull); errors.Is(err, ErrRateLimited) {
+					return err
+				} else if err != nil {
+					pushErr = err
+				}
+			}
+			idx += 1
+		}
+		*page = nextURL
+	}
+	return pushErr
+}
+
+// backfillIssues indexes the issues updated in the window from page on, errors are handled like backfillPullRequests
+func (c *Crawler) backfillIssues(repository Repository, window backfillWindow, page *string) error {
+	var pushErr error
+	idx := 0
+	for *page != "" {
+		issues, nextURL, err := c.getIssuesPage(*page, false)
+		if err != nil {
+			return err
+		}
+		for _, issue := range issues {
+			if issue.PullRequest != nil || issue.UpdatedAt.After(window.until) {
+				continue
+			}
+			if err := c.indexIssue(repository, idx, issue, time.Since(issue.CreatedAt)); err != nil {
+				pushErr = err
+			}
+			idx += 1
+		}
+		*page = nextURL
+	}
+	return pushErr
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func Test_Backfill(t *testing.T) {
+	setupTestlogging()
+	window := backfillWindow{since: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), until: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)}
+	server := newTestGithubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/owner/repo":
+			w.Write([]byte(`{"full_name":"owner/repo","owner":{"login":"owner"}}`))
+		case "/repos/owner/repo/pulls":
+			fmt.Fprintf(w, `[
+				{"id":3,"number":3,"state":"open","updated_at":"2024-03-01T00:00:00Z","base":{"repo":{"full_name":"owner/repo"}}},
+				{"id":2,"number":2,"state":"closed","updated_at":"2024-01-15T00:00:00Z","closed_at":"2024-01-15T00:00:00Z","base":{"repo":{"full_name":"owner/repo"}}},
+				{"id":1,"number":1,"state":"closed","updated_at":"2023-12-01T00:00:00Z","closed_at":"2023-12-01T00:00:00Z","base":{"repo":{"full_name":"owner/repo"}}}]`)
+		default:
+			t.Errorf("unexpected %v %v", r.Method, r.URL)
+		}
+	})
+	search := newTestSearch(t, func(documentID string) int { return http.StatusCreated })
+	c := &Crawler{Config: ConfigGithub{APIURL: server.URL, CrawlEvents: []string{"pull_request"}}, Sink: search}
+	created := documentCount(t, "created")
+	done, failed := c.backfill([]string{"owner/repo"}, window)
+	if err := search.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if done != 1 || failed != 0 {
+		t.Errorf("expected 1 repository done, got %v done %v failed", done, failed)
+	}
+	if documentCount(t, "created")-created != 1 {
+		t.Errorf("only the pull request updated within the window should be indexed")
+	}
+}
+
+func Test_BackfillReportsRejectedDocuments(t *testing.T) {
+	setupTestlogging()
+	window := backfillWindow{since: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), until: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)}
+	server := newTestGithubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/owner/repo":
+			w.Write([]byte(`{"full_name":"owner/repo","owner":{"login":"owner"}}`))
+		default:
+			fmt.Fprintf(w, `[{"id":2,"number":2,"state":"open","updated_at":"2024-01-15T00:00:00Z","base":{"repo":{"full_name":"owner/repo"}}}]`)
+		}
+	})
+	search := newTestSearch(t, func(documentID string) int { return http.StatusBadRequest })
+	c := &Crawler{Config: ConfigGithub{APIURL: server.URL, CrawlEvents: []string{"pull_request"}}, Sink: search}
+	done, failed := c.backfill([]string{"owner/repo"}, window)
+	if done != 1 || failed != 0 {
+		t.Errorf("documents should be queued without errors, got %v done %v failed", done, failed)
+	}
+	rejected, err := flushBackfill(search)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(rejected, []string{"owner/repo"}) {
+		t.Errorf("repository with rejected documents should be reported, got %v", rejected)
+	}
+}
+
+func Test_BackfillResumesAfterRateLimit(t *testing.T) {
+	setupTestlogging()
+	window := backfillWindow{since: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), until: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)}
+	pages := []string{}
+	var server *httptest.Server
+	server = newTestGithubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/repos/owner/repo" {
+			w.Write([]byte(`{"full_name":"owner/repo","owner":{"login":"owner"}}`))
+			return
+		}
+		page := r.URL.Query().Get("page")
+		pages = append(pages, page)
+		switch {
+		case page == "":
+			w.Header().Set("Link", fmt.Sprintf("<%v/repos/owner/repo/pulls?page=2>; rel=\"next\"", server.URL))
+			fmt.Fprintf(w, `[{"id":2,"number":2,"state":"open","updated_at":"2024-01-20T00:00:00Z","base":{"repo":{"full_name":"owner/repo"}}}]`)
+		case len(pages) == 2:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			fmt.Fprintf(w, `[{"id":1,"number":1,"state":"open","updated_at":"2024-01-10T00:00:00Z","base":{"repo":{"full_name":"owner/repo"}}}]`)
+		}
+	})
+	search := newTestSearch(t, func(documentID string) int { return http.StatusCreated })
+	c := &Crawler{Config: ConfigGithub{APIURL: server.URL, CrawlEvents: []string{"pull_request"}}, Sink: search}
+	created := documentCount(t, "created")
+	done, failed := c.backfill([]string{"owner/repo"}, window)
+	if err := search.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if done != 1 || failed != 0 {
+		t.Errorf("expected 1 repository done, got %v done %v failed", done, failed)
+	}
+	if !slices.Equal(pages, []string{"", "2", "2"}) {
+		t.Errorf("backfill should continue from the rate limited page, requested %v", pages)
+	}
+	if documentCount(t, "created")-created != 2 {
+		t.Errorf("expected both pull requests to be indexed once")
+	}
+}
+
+func Test_ParseEvents(t *testing.T) {
+	if events := parseEvents(" issues, pull_request ,,"); !slices.Equal(events, []string{"issues", "pull_request"}) {
+		t.Errorf("unexpected events %v", events)
+	}
+}
+
+func Test_ParseBackfillTime(t *testing.T) {
+	for _, value := range []string{"2024-01-02", "2024-01-02T00:00:00Z"} {
+		parsed, err := parseBackfillTime(value)
+		if err != nil || !parsed.Equal(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("%v parsed to %v, %v", value, parsed, err)
+		}
+	}
+}
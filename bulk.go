@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v9/esutil"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	elastic_documents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "elastic_documents_total",
+		Help: "Documents sent to elasticsearch by result (created, conflict, failed)",
+	}, []string{"result"})
+	elastic_documents_retried = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "elastic_documents_retried_total",
+		Help: "Documents resent to elasticsearch after a 429 or 5xx",
+	})
+)
+
+var (
+	ErrNotAnObject = errors.New("error, document is not a json object")
+	ErrSinkClosed  = errors.New("error, sink closed")
+)
+
+type ConfigBulk struct {
+	Workers       int           `mapstructure:"workers"`
+	FlushBytes    int           `mapstructure:"flush_bytes"`
+	FlushInterval time.Duration `mapstructure:"flush_interval"`
+	MaxRetries    int           `mapstructure:"max_retries"`
+}
+
+// bulkDocument is one document waiting in the bulk indexer
+type bulkDocument struct {
+	index      string
+	repository string
+	documentID string
+	body       []byte
+	attempt    int
+	attrs      []any
+}
+
+func (d *bulkDocument) logAttrs(attrs ...any) []any {
+	return append(append([]any{}, d.attrs...), attrs...)
+}
+
+func (s *Search) startIndexer(cfg ConfigBulk) {
+	var err error
+	s.maxRetries = cfg.MaxRetries
+	s.indexer, err = esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
+		Client:        s.esClient,
+		Index:         s.index,
+		NumWorkers:    cfg.Workers,
+		FlushBytes:    cfg.FlushBytes,
+		FlushInterval: cfg.FlushInterval,
+		OnError: func(ctx context.Context, err error) {
+			logger.Error("bulk indexer error", "error", err)
+		},
+	})
+	if err != nil {
+		logger.Error("error starting bulk indexer", "error", err)
+		os.Exit(1)
+	}
+}
+
+// createDocument queues a document to be created, attrs are used when logging the result
+func (s *Search) createDocument(documentID string, body []byte, attrs ...any) error {
+	return s.createDocumentIn(s.index, s.dataStream, "", documentID, nil, body, attrs...)
+}
+
+// createEvent queues an event in the index or data stream the router picks for it
+func (s *Search) createEvent(eventType string, repository string, documentID string, event any, body []byte, attrs ...any) error {
+	index, dataStream := s.router.target(eventType, repository, event)
+	if index == "" {
+		index, dataStream = s.index, s.dataStream
+	}
+	return s.createDocumentIn(index, dataStream, repository, documentID, event, body, attrs...)
+}
+
+func (s *Search) createDocumentIn(index string, dataStream bool, repository string, documentID string, event any, body []byte, attrs ...any) error {
+	if dataStream {
+		var err error
+		body, err = withTimestamp(body, event)
+		if err != nil {
+			return err
+		}
+	}
+	return s.add(&bulkDocument{index: index, repository: repository, documentID: documentID, body: body, attrs: attrs})
+}
+
+// withTimestamp adds the @timestamp field data streams require, set to when the event happened on GitHub
+func withTimestamp(body []byte, event any) ([]byte, error) {
+	return prependField(body, "@timestamp", eventTime(event).UTC().Format(time.RFC3339Nano))
+}
+
+// prependField adds a field at the start of a json object without decoding the rest of it
+func prependField(body []byte, name string, value any) ([]byte, error) {
+	document := bytes.TrimSpace(body)
+	if len(document) < 2 || document[0] != '{' {
+		return nil, ErrNotAnObject
+	}
+	field, err := json.Marshal(map[string]any{name: value})
+	if err != nil {
+		return nil, err
+	}
+	prefix := field[:len(field)-1]
+	if len(bytes.TrimSpace(document[1:])) > 1 {
+		prefix = append(prefix, ',')
+	}
+	return append(prefix, document[1:]...), nil
+}
+
+func (s *Search) add(document *bulkDocument) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return ErrSinkClosed
+	}
+	return s.queue(document)
+}
+
+// queue adds to the indexer without checking closed, retries scheduled before Close are still queued
+func (s *Search) queue(document *bulkDocument) error {
+	return s.indexer.Add(context.Background(), esutil.BulkIndexerItem{
+		Index:      document.index,
+		Action:     "create",
+		DocumentID: document.documentID,
+		Body:       bytes.NewReader(document.body),
+		OnSuccess: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
+			elastic_documents.WithLabelValues("created").Inc()
+			logger.Info("Pushed document", document.logAttrs("index", res.Index, "documentID", res.DocumentID)...)
+		},
+		OnFailure: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+			s.onFailure(document, res, err)
+		},
+	})
+}
+
+func (s *Search) onFailure(document *bulkDocument, res esutil.BulkIndexerResponseItem, err error) {
+	if res.Status == http.StatusConflict {
+		elastic_documents.WithLabelValues("conflict").Inc()
+		debugLogger.Debug("Pushed document - Already exists", document.logAttrs("documentID", document.documentID)...)
+		return
+	}
+	retryable := res.Status == http.StatusTooManyRequests || res.Status >= http.StatusInternalServerError
+	if retryable && document.attempt < s.maxRetries && s.scheduleRetry() {
+		document.attempt += 1
+		backoff := time.Duration(1<<document.attempt) * time.Second
+		debugLogger.Debug("retrying document", "documentID", document.documentID, "status", res.Status, "attempt", document.attempt, "backoff", backoff)
+		elastic_documents_retried.Inc()
+		time.AfterFunc(backoff, func() {
+			defer s.retries.Done()
+			if err := s.queue(document); err != nil {
+				elastic_documents.WithLabelValues("failed").Inc()
+				s.failures.add(document.repository)
+				logger.Error("error requeuing document", "documentID", document.documentID, "error", err)
+			}
+		})
+		return
+	}
+	elastic_documents.WithLabelValues("failed").Inc()
+	s.failures.add(document.repository)
+	if err != nil {
+		logger.Error("error pushing document", document.logAttrs("documentID", document.documentID, "error", err)...)
+		return
+	}
+	logger.Error("error pushing document", document.logAttrs("documentID", document.documentID, "status", res.Status, "type", res.Error.Type, "reason", res.Error.Reason)...)
+}
+
+func (s *Search) failedRepositories() []string {
+	return s.failures.take()
+}
+
+// scheduleRetry counts a pending retry unless Close has started, retries.Add never races retries.Wait
+func (s *Search) scheduleRetry() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return false
+	}
+	s.retries.Add(1)
+	return true
+}
+
+// Close refuses new documents, waits for pending retries and flushes what is left in the indexer
+func (s *Search) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.retries.Wait()
+	return s.indexer.Close(context.Background())
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v9"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func documentCount(t *testing.T, result string) float64 {
+	metric := new(dto.Metric)
+	if err := elastic_documents.WithLabelValues(result).Write(metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetCounter().GetValue()
+}
+
+// newTestSearch starts an elasticsearch stand-in answering _bulk with the status chosen per document id
+func newTestSearch(t *testing.T, statusFor func(documentID string) int) *Search {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		if !strings.HasSuffix(r.URL.Path, "/_bulk") {
+			w.Write([]byte(`{}`))
+			return
+		}
+		items := []string{}
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var meta map[string]struct {
+				Index string `json:"_index"`
+				ID    string `json:"_id"`
+			}
+			if err := json.Unmarshal(scanner.Bytes(), &meta); err != nil {
+				t.Error(err)
+			}
+			create := meta["create"]
+			items = append(items, fmt.Sprintf(`{"create":{"_index":%q,"_id":%q,"status":%v}}`, create.Index, create.ID, statusFor(create.ID)))
+			scanner.Scan()
+		}
+		fmt.Fprintf(w, `{"took":1,"errors":true,"items":[%v]}`, strings.Join(items, ","))
+	}))
+	t.Cleanup(server.Close)
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	search := &Search{esClient: client, index: "test-index"}
+	search.startIndexer(ConfigBulk{Workers: 1, FlushInterval: time.Hour})
+	return search
+}
+
+func Test_BulkIndexerResults(t *testing.T) {
+	setupTestlogging()
+	search := newTestSearch(t, func(documentID string) int {
+		switch documentID {
+		case "exists":
+			return http.StatusConflict
+		case "broken":
+			return http.StatusInternalServerError
+		}
+		return http.StatusCreated
+	})
+	created, conflict, failed := documentCount(t, "created"), documentCount(t, "conflict"), documentCount(t, "failed")
+	for _, id := range []string{"new", "exists"} {
+		if err := search.createDocument(id, []byte(`{}`), "test", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := search.createEvent("issues", "owner/repo", "broken", &IssuesEvent{}, []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := search.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if documentCount(t, "created")-created != 1 {
+		t.Errorf("expected 1 created document")
+	}
+	if documentCount(t, "conflict")-conflict != 1 {
+		t.Errorf("expected 1 conflicting document")
+	}
+	if documentCount(t, "failed")-failed != 1 {
+		t.Errorf("expected 1 failed document")
+	}
+	if repositories := search.failedRepositories(); len(repositories) != 1 || repositories[0] != "owner/repo" {
+		t.Errorf("repository of the failed document should be reported, got %v", repositories)
+	}
+	if err := search.createDocument("late", []byte(`{}`)); err != ErrSinkClosed {
+		t.Errorf("%v should be %v", err, ErrSinkClosed)
+	}
+}
+
+func Test_WithTimestamp(t *testing.T) {
+	updated := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	event := &IssuesEvent{Timestamp: time.Now(), Action: "opened", Issue: &Issue{UpdatedAt: updated}}
+	body, err := withTimestamp([]byte(`{"timestamp":"2026-01-01T00:00:00Z","action":"opened"}`), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document map[string]any
+	if err := json.Unmarshal(body, &document); err != nil {
+		t.Fatalf("%v is not valid json: %v", string(body), err)
+	}
+	if document["@timestamp"] != "2025-01-02T03:04:05Z" || document["action"] != "opened" {
+		t.Errorf("unexpected document %v", document)
+	}
+	if _, err := withTimestamp([]byte(`[]`), nil); err == nil {
+		t.Errorf("array should be rejected")
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	ErrNotModified = errors.New("not modified")
+
+	github_cache_requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "github_cache_requests_total",
+		Help: "Conditional GitHub requests by result (not_modified, modified)",
+	}, []string{"result"})
+)
+
+type ConfigCache struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Path is a directory to keep the cache in between restarts, only memory is used when empty
+	Path       string `mapstructure:"path"`
+	MaxEntries int    `mapstructure:"max_entries"`
+	// MaxBytes limits the size of the cached bodies, in memory and on disk
+	MaxBytes int `mapstructure:"max_bytes"`
+}
+
+// cacheEntry is a response that can be validated with If-None-Match or If-Modified-Since. Key is the url
+// prefixed by who requested it, as what a request returns depends on the token used
+type cacheEntry struct {
+	Key          string          `json:"key"`
+	URL          string          `json:"url"`
+	ETag         string          `json:"etag,omitempty"`
+	LastModified string          `json:"last_modified,omitempty"`
+	NextURL      string          `json:"next_url,omitempty"`
+	Body         json.RawMessage `json:"body,omitempty"`
+	StoredAt     time.Time       `json:"stored_at"`
+}
+
+func (e *cacheEntry) size() int {
+	return len(e.Body)
+}
+
+// responseCache keeps validators and bodies per key, shared by all copies of a client. order has the
+// most recently used entry in front, entries are dropped from the back when full. With a path every entry
+// in memory also has a file, files are read at start and deleted with their entry
+type responseCache struct {
+	mu         sync.Mutex
+	entries    map[string]*list.Element
+	order      *list.List
+	bytes      int
+	path       string
+	maxEntries int
+	maxBytes   int
+}
+
+func newResponseCache(config ConfigCache) *responseCache {
+	if !config.Enabled {
+		return nil
+	}
+	if config.Path != "" {
+		if err := os.MkdirAll(config.Path, 0o700); err != nil {
+			logger.Error("error creating cache directory, using memory only", "path", config.Path, "error", err)
+			config.Path = ""
+		}
+	}
+	maxEntries := config.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = 10000
+	}
+	maxBytes := config.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = 64 << 20
+	}
+	cache := &responseCache{entries: make(map[string]*list.Element), order: list.New(), path: config.Path, maxEntries: maxEntries, maxBytes: maxBytes}
+	cache.load()
+	return cache
+}
+
+// load reads the entries kept on disk, oldest first so the most recently stored end up in front. Files
+// that cannot be read or do not fit are deleted
+func (c *responseCache) load() {
+	if c.path == "" {
+		return
+	}
+	files, err := filepath.Glob(filepath.Join(c.path, "*.json"))
+	if err != nil {
+		return
+	}
+	var entries []*cacheEntry
+	for _, file := range files {
+		bodyText, err := os.ReadFile(file)
+		entry := new(cacheEntry)
+		if err != nil || json.Unmarshal(bodyText, entry) != nil || c.file(entry.Key) != file {
+			os.Remove(file)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].StoredAt.Before(entries[j].StoredAt)
+	})
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, entry := range entries {
+		c.store(entry)
+	}
+}
+
+func (c *responseCache) get(key string) *cacheEntry {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, found := c.entries[key]; found {
+		c.order.MoveToFront(element)
+		return element.Value.(*cacheEntry)
+	}
+	return nil
+}
+
+func (c *responseCache) put(entry *cacheEntry) {
+	if c == nil {
+		return
+	}
+	entry.StoredAt = time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.store(entry) || c.path == "" {
+		return
+	}
+	byteArray, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(c.file(entry.Key), byteArray, 0o600); err != nil {
+		debugLogger.Debug("error writing cache entry", "url", entry.URL, "error", err)
+	}
+}
+
+// remove drops the entry for key so the next request is sent without validators
+func (c *responseCache) remove(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, found := c.entries[key]; found {
+		c.drop(element)
+	}
+}
+
+// store adds to memory, dropping the least recently used entries until it fits. An entry larger than
+// maxBytes is not stored. Must be called with mu held
+func (c *responseCache) store(entry *cacheEntry) bool {
+	if element, found := c.entries[entry.Key]; found {
+		c.drop(element)
+	}
+	if entry.size() > c.maxBytes {
+		return false
+	}
+	for c.order.Len() >= c.maxEntries || c.bytes+entry.size() > c.maxBytes {
+		c.drop(c.order.Back())
+	}
+	c.entries[entry.Key] = c.order.PushFront(entry)
+	c.bytes += entry.size()
+	return true
+}
+
+// drop removes an entry from memory and disk. Must be called with mu held
+func (c *responseCache) drop(element *list.Element) {
+	entry := element.Value.(*cacheEntry)
+	c.order.Remove(element)
+	delete(c.entries, entry.Key)
+	c.bytes -= entry.size()
+	if c.path == "" {
+		return
+	}
+	if err := os.Remove(c.file(entry.Key)); err != nil && !os.IsNotExist(err) {
+		debugLogger.Debug("error removing cache entry", "key", entry.Key, "error", err)
+	}
+}
+
+func (c *responseCache) file(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.path, hex.EncodeToString(sum[:])+".json")
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"testing"
+)
+
+func Test_ConditionalRequests(t *testing.T) {
+	setupTestlogging()
+	notModified := 0
+	server := newTestGithubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified += 1
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(`[{"id":1,"full_name":"owner/one"}]`))
+	})
+	path := t.TempDir()
+	config := ConfigGithub{APIURL: server.URL, Cache: ConfigCache{Enabled: true, Path: path}}
+	client := NewGithubClient(config)
+	var first []Repository
+	if _, err := client.getConditional(client.url("/user/repos"), &first); err != nil {
+		t.Fatal(err)
+	}
+	var second []Repository
+	if _, err := client.getConditional(client.url("/user/repos"), &second); err != ErrNotModified {
+		t.Fatalf("second request should be %v, got %v", ErrNotModified, err)
+	}
+	if len(second) != 1 || second[0].FullName != "owner/one" {
+		t.Errorf("not modified response should be served from cache, got %v", second)
+	}
+	// a new client reads the validators from disk
+	client = NewGithubClient(config)
+	var third []Repository
+	if _, err := client.get(client.url("/user/repos"), &third); err != nil {
+		t.Fatal(err)
+	}
+	if notModified != 2 || len(third) != 1 {
+		t.Errorf("expected 2 not modified responses and cached body, got %v %v", notModified, third)
+	}
+}
+
+func Test_CacheEviction(t *testing.T) {
+	cache := newResponseCache(ConfigCache{Enabled: true, MaxEntries: 2})
+	cache.put(&cacheEntry{Key: "a", ETag: "a"})
+	cache.put(&cacheEntry{Key: "b", ETag: "b"})
+	cache.get("a")
+	cache.put(&cacheEntry{Key: "c", ETag: "c"})
+	if len(cache.entries) != 2 || cache.get("b") != nil || cache.get("a") == nil {
+		t.Errorf("least recently used entry should be evicted, got %v", cache.entries)
+	}
+}
+
+func Test_CacheEvictionBySize(t *testing.T) {
+	setupTestlogging()
+	path := t.TempDir()
+	cache := newResponseCache(ConfigCache{Enabled: true, Path: path, MaxBytes: 10})
+	cache.put(&cacheEntry{Key: "a", Body: []byte(`"aaaa"`)})
+	cache.put(&cacheEntry{Key: "b", Body: []byte(`"bbbb"`)})
+	if cache.get("a") != nil || cache.get("b") == nil || cache.bytes != 6 {
+		t.Errorf("oldest entry should be evicted to stay within max bytes, got %v bytes", cache.bytes)
+	}
+	if _, err := os.Stat(cache.file("a")); !os.IsNotExist(err) {
+		t.Errorf("evicted entry should be deleted from disk, got %v", err)
+	}
+	cache.put(&cacheEntry{Key: "large", Body: []byte(`"larger than max"`)})
+	if cache.get("large") != nil || cache.get("b") == nil {
+		t.Errorf("entry larger than max bytes should not be stored")
+	}
+	// a restart reads the entries left on disk
+	cache = newResponseCache(ConfigCache{Enabled: true, Path: path, MaxBytes: 10})
+	if cache.get("b") == nil || len(cache.entries) != 1 {
+		t.Errorf("entry on disk should be loaded, got %v", cache.entries)
+	}
+}
+
+func Test_WorkflowRunsNotCached(t *testing.T) {
+	setupTestlogging()
+	server := newTestGithubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(`{"workflow_runs":[]}`))
+	})
+	c := &Crawler{Config: ConfigGithub{APIURL: server.URL, Cache: ConfigCache{Enabled: true}}, Sink: &recordingSink{}}
+	if err := c.crawlWorkflowRuns(Repository{FullName: "owner/repo"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.client().cache.entries) != 0 {
+		t.Errorf("urls with the current time should not be cached, got %v entries", len(c.client().cache.entries))
+	}
+}
+
+func Test_CacheKeyPerIdentity(t *testing.T) {
+	setupTestlogging()
+	path := t.TempDir()
+	first := NewGithubClient(ConfigGithub{Token: "first", Cache: ConfigCache{Enabled: true, Path: path}})
+	second := *first
+	second.Token = "second"
+	url := first.url("/user/repos")
+	if first.cacheKey(url) == second.cacheKey(url) {
+		t.Errorf("tokens should not share cache entries")
+	}
+	if first.withInstallation(1).cacheKey(url) == first.withInstallation(2).cacheKey(url) {
+		t.Errorf("installations should not share cache entries")
+	}
+	first.cache.put(&cacheEntry{Key: first.cacheKey(url), URL: url, ETag: "v1"})
+	info, err := os.Stat(first.cache.file(first.cacheKey(url)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("cache files should only be readable by the owner, got %v", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v9/esapi"
+)
+
+type RepositoryCheckpoint struct {
+	LastCrawled        time.Time `json:"last_crawled"`
+	LastUpdatedAt      time.Time `json:"last_updated_at"`
+	LastIssueUpdatedAt time.Time `json:"last_issue_updated_at"`
+}
+
+// Checkpoint is the crawl progress that survives restarts
+type Checkpoint struct {
+	UpdatedAt    time.Time                        `json:"updated_at"`
+	Repositories map[string]*RepositoryCheckpoint `json:"repositories"`
+	// RotatedHooks are the hooks given the new secret during the secret rotation identified by Rotation
+	Rotation     string   `json:"rotation,omitempty"`
+	RotatedHooks []string `json:"rotated_hooks,omitempty"`
+}
+
+func NewCheckpoint() *Checkpoint {
+	return &Checkpoint{Repositories: make(map[string]*RepositoryCheckpoint)}
+}
+
+func (c *Checkpoint) repository(fullName string) *RepositoryCheckpoint {
+	repo, found := c.Repositories[fullName]
+	if !found {
+		repo = new(RepositoryCheckpoint)
+		c.Repositories[fullName] = repo
+	}
+	return repo
+}
+
+// sortRepositories puts the repositories crawled longest ago first, never crawled repositories before all others
+func (c *Checkpoint) sortRepositories(list []Repository) {
+	sort.SliceStable(list, func(i, j int) bool {
+		return c.lastCrawled(list[i].FullName).Before(c.lastCrawled(list[j].FullName))
+	})
+}
+
+// prioritizeRepositories puts repositories pushed to since they were last crawled first, most recently pushed
+// first, followed by the rest ordered like sortRepositories
+func (c *Checkpoint) prioritizeRepositories(list []Repository) {
+	c.sortRepositories(list)
+	sort.SliceStable(list, func(i, j int) bool {
+		iPushed := list[i].PushedAt.After(c.lastCrawled(list[i].FullName))
+		jPushed := list[j].PushedAt.After(c.lastCrawled(list[j].FullName))
+		if iPushed && jPushed {
+			return list[i].PushedAt.After(list[j].PushedAt)
+		}
+		return iPushed && !jPushed
+	})
+}
+
+func (c *Checkpoint) lastCrawled(fullName string) time.Time {
+	repo, found := c.Repositories[fullName]
+	if !found {
+		return time.Time{}
+	}
+	return repo.LastCrawled
+}
+
+type CheckpointStore interface {
+	Load() (*Checkpoint, error)
+	Save(checkpoint *Checkpoint) error
+}
+
+type ConfigCheckpoint struct {
+	Type  string `mapstructure:"type"`
+	Path  string `mapstructure:"path"`
+	Index string `mapstructure:"index"`
+	ID    string `mapstructure:"id"`
+}
+
+func (cfg *ConfigCheckpoint) getStore(sink Sink) CheckpointStore {
+	switch cfg.Type {
+	case "file":
+		return &FileCheckpointStore{Path: cfg.Path}
+	case "elastic":
+		search := elasticSearch(sink)
+		if search == nil {
+			logger.Error("checkpoint type elastic needs the elastic output with elastic.type elasticsearch")
+			os.Exit(1)
+		}
+		return &ElasticCheckpointStore{ES: search, Index: cfg.Index, ID: cfg.ID}
+	case "", "none":
+		return nil
+	}
+	logger.Error("unknown checkpoint type", "type", cfg.Type)
+	os.Exit(1)
+	return nil
+}
+
+type FileCheckpointStore struct {
+	Path string
+}
+
+func (s *FileCheckpointStore) Load() (*Checkpoint, error) {
+	bodyText, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return NewCheckpoint(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	checkpoint := NewCheckpoint()
+	if err := json.Unmarshal(bodyText, checkpoint); err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+// Save writes to a temporary file first so a crash never leaves a half written checkpoint
+func (s *FileCheckpointStore) Save(checkpoint *Checkpoint) error {
+	byteArray, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.Path), filepath.Base(s.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(byteArray); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.Path)
+}
+
+// checkpointMappings keeps the repositories out of the mapping, dynamically mapped every repository name
+// would be a field, counting towards index.mapping.total_fields.limit, and dots would nest objects
+const checkpointMappings = `{
+  "mappings": {
+    "dynamic": false,
+    "properties": {
+      "updated_at": {"type": "date"},
+      "repositories": {"type": "object", "enabled": false}
+    }
+  }
+}`
+
+// ElasticCheckpointStore keeps the checkpoint as a single document in a side index
+type ElasticCheckpointStore struct {
+	ES    *Search
+	Index string
+	ID    string
+}
+
+// ensureIndex creates the checkpoint index with checkpointMappings, an existing index mapping repositories
+// as fields is only reported
+func (s *ElasticCheckpointStore) ensureIndex() error {
+	res, err := esapi.IndicesGetMappingRequest{Index: []string{s.Index}}.Do(context.Background(), s.ES.esClient)
+	if err != nil {
+		return err
+	}
+	var existing map[string]struct {
+		Mappings struct {
+			Properties map[string]struct {
+				Enabled *bool `json:"enabled"`
+			} `json:"properties"`
+		} `json:"mappings"`
+	}
+	found, err := decodeSetupResponse(res, "error reading checkpoint index mapping", &existing)
+	if err != nil {
+		return err
+	}
+	if found {
+		for _, index := range existing {
+			repositories, mapped := index.Mappings.Properties["repositories"]
+			if mapped && (repositories.Enabled == nil || *repositories.Enabled) {
+				logger.Warn("checkpoint index maps every repository as a field, delete it to have it recreated", "index", s.Index)
+			}
+		}
+		return nil
+	}
+	res, err = esapi.IndicesCreateRequest{Index: s.Index, Body: strings.NewReader(checkpointMappings)}.Do(context.Background(), s.ES.esClient)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		printESError("error creating checkpoint index", res)
+		return ErrStatusNotAccepted
+	}
+	return nil
+}
+
+func (s *ElasticCheckpointStore) Load() (*Checkpoint, error) {
+	if err := s.ensureIndex(); err != nil {
+		return nil, err
+	}
+	res, err := esapi.GetRequest{
+		Index:      s.Index,
+		DocumentID: s.ID,
+	}.Do(context.Background(), s.ES.esClient)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return NewCheckpoint(), nil
+	}
+	if res.IsError() {
+		printESError("error reading checkpoint", res)
+		return nil, ErrStatusNotAccepted
+	}
+	document := struct {
+		Source *Checkpoint `json:"_source"`
+	}{Source: NewCheckpoint()}
+	if err := json.NewDecoder(res.Body).Decode(&document); err != nil {
+		return nil, err
+	}
+	return document.Source, nil
+}
+
+func (s *ElasticCheckpointStore) Save(checkpoint *Checkpoint) error {
+	byteArray, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	res, err := esapi.IndexRequest{
+		Index:      s.Index,
+		DocumentID: s.ID,
+		Body:       bytes.NewReader(byteArray),
+	}.Do(context.Background(), s.ES.esClient)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		printESError("error saving checkpoint", res)
+		return ErrStatusNotAccepted
+	}
+	return nil
+}
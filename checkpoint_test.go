@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v9"
+)
+
+func Test_FileCheckpointStore(t *testing.T) {
+	setupTestlogging()
+	store := &FileCheckpointStore{Path: filepath.Join(t.TempDir(), "checkpoint.json")}
+	checkpoint, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checkpoint.Repositories) != 0 {
+		t.Errorf("missing file should give empty checkpoint, got %v", checkpoint.Repositories)
+	}
+	crawled := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	checkpoint.repository("owner/repo").LastCrawled = crawled
+	if err := store.Save(checkpoint); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.lastCrawled("owner/repo").Equal(crawled) {
+		t.Errorf("%v should be %v", loaded.lastCrawled("owner/repo"), crawled)
+	}
+}
+
+func Test_CheckpointSortRepositories(t *testing.T) {
+	checkpoint := NewCheckpoint()
+	checkpoint.repository("owner/recent").LastCrawled = time.Now()
+	checkpoint.repository("owner/old").LastCrawled = time.Now().Add(-time.Hour)
+	list := []Repository{{FullName: "owner/recent"}, {FullName: "owner/old"}, {FullName: "owner/new"}}
+	checkpoint.sortRepositories(list)
+	wanted := []string{"owner/new", "owner/old", "owner/recent"}
+	for idx, name := range wanted {
+		if list[idx].FullName != name {
+			t.Errorf("[%v] %v should be %v", idx, list[idx].FullName, name)
+		}
+	}
+}
+
+func Test_CheckpointPrioritizeRepositories(t *testing.T) {
+	checkpoint := NewCheckpoint()
+	checkpoint.repository("owner/pushed").LastCrawled = time.Now().Add(-time.Hour)
+	checkpoint.repository("owner/quiet").LastCrawled = time.Now().Add(-2 * time.Hour)
+	checkpoint.repository("owner/busy").LastCrawled = time.Now().Add(-time.Hour)
+	list := []Repository{
+		{FullName: "owner/quiet", PushedAt: time.Now().Add(-3 * time.Hour)},
+		{FullName: "owner/pushed", PushedAt: time.Now().Add(-30 * time.Minute)},
+		{FullName: "owner/busy", PushedAt: time.Now().Add(-time.Minute)},
+	}
+	checkpoint.prioritizeRepositories(list)
+	wanted := []string{"owner/busy", "owner/pushed", "owner/quiet"}
+	for idx, name := range wanted {
+		if list[idx].FullName != name {
+			t.Errorf("[%v] %v should be %v", idx, list[idx].FullName, name)
+		}
+	}
+}
+
+func Test_ElasticCheckpointStoreCreatesIndex(t *testing.T) {
+	setupTestlogging()
+	var created map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/checkpoints":
+			if err := json.NewDecoder(r.Body).Decode(&created); err != nil {
+				t.Error(err)
+			}
+			w.Write([]byte(`{"acknowledged":true}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &ElasticCheckpointStore{ES: &Search{esClient: client}, Index: "checkpoints", ID: "crawler"}
+	checkpoint, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checkpoint.Repositories) != 0 {
+		t.Errorf("missing document should give empty checkpoint, got %v", checkpoint.Repositories)
+	}
+	if created == nil {
+		t.Fatal("checkpoint index should be created")
+	}
+	if changes := diffJSON("", created, json.RawMessage(checkpointMappings)); len(changes) != 0 {
+		t.Errorf("index should be created with the checkpoint mappings, got %v", changes)
+	}
+}
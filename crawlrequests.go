@@ -1,42 +1,118 @@
 package main
 
 import (
-	"bytes"
-	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"net/http"
-	"strconv"
+	"sync"
 	"time"
+)
 
-	"github.com/elastic/go-elasticsearch/v9/esapi"
-	"github.com/tomnomnom/linkheader"
+const (
+	// closed pull requests are only pushed within this window
+	crawlWindow = 48 * time.Hour
 )
 
 var (
 	ErrStatusNotAccepted  = errors.New("error, wrong status")
 	ErrStatusUnauthorized = errors.New("error, not authorized")
+	ErrStatusForbidden    = errors.New("error, forbidden")
+	ErrStatusNotFound     = errors.New("error, not found")
+	ErrRateLimited        = errors.New("error, rate limited")
 )
 
+// Crawler walks the repositories, a batch of them per tick. mu guards the checkpoint as
+// repositories of a batch are crawled concurrently
 type Crawler struct {
-	Config    ConfigGithub
-	ES        *Search
-	next      int
-	list      []Repository
-	remaining int
-	lowNotise bool
+	Config      ConfigGithub
+	Sink        Sink
+	Client      *GithubClient
+	Checkpoints CheckpointStore
+	Rotation    *SecretRotation
+	Filter      *RepositoryFilter
+	mu          sync.Mutex
+	checkpoint  *Checkpoint
+	next        int
+	list        []Repository
+	// orgHooks are the lower cased organizations with a working hook, set by updateOrgWebHooks
+	orgHooks map[string]bool
+	// orgCovered are the repositories already checked for hooks duplicating their organization hook
+	orgCovered map[string]bool
+}
+
+func (c *Crawler) client() *GithubClient {
+	if c.Client == nil {
+		c.Client = NewGithubClient(c.Config)
+	}
+	return c.Client
+}
+
+// loadCheckpoint reads where the last run stopped. Without a store progress is only kept in memory
+func (c *Crawler) loadCheckpoint() {
+	if c.Checkpoints != nil {
+		checkpoint, err := c.Checkpoints.Load()
+		if err != nil {
+			logger.Error("error loading checkpoint, starting from scratch", "error", err)
+		} else {
+			logger.Info("Checkpoint loaded", "repositories", len(checkpoint.Repositories), "updated", checkpoint.UpdatedAt)
+			c.checkpoint = checkpoint
+			return
+		}
+	}
+	c.checkpoint = NewCheckpoint()
+}
+
+func (c *Crawler) saveCheckpoint() {
+	c.checkpoint.UpdatedAt = time.Now()
+	if c.Checkpoints == nil {
+		return
+	}
+	err := c.Checkpoints.Save(c.checkpoint)
+	if err != nil {
+		logger.Error("error saving checkpoint", "error", err)
+	}
+}
+
+// holdFailedRepositories moves the checkpoint of repositories with documents the sink failed to write after
+// they were queued back to the start of the crawl window, so the next crawl queues them again
+func (c *Crawler) holdFailedRepositories() {
+	reporter, ok := c.Sink.(failureReporter)
+	if !ok {
+		return
+	}
+	repositories := reporter.failedRepositories()
+	if len(repositories) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, fullName := range repositories {
+		logger.Warn("documents failed after queuing, crawling the repository again", "repository", fullName)
+		progress := c.checkpoint.repository(fullName)
+		progress.LastUpdatedAt = time.Time{}
+		progress.LastIssueUpdatedAt = time.Time{}
+		c.client().uncache(c.pullRequestsURL(fullName))
+	}
+	c.saveCheckpoint()
 }
 
 func (c *Crawler) Tick() {
 	debugLogger.Debug("Tick Event")
+	if until := c.client().limits.blockedUntil(); !until.IsZero() {
+		logger.Info("Rate limited, skipping tick", "until", until)
+		return
+	}
+	if c.checkpoint == nil {
+		c.loadCheckpoint()
+	}
+	c.holdFailedRepositories()
 	if c.list == nil || len(c.list) == 0 {
 		list, err := c.ListRepositories()
 		if err == ErrStatusUnauthorized {
 			panic(err)
 		}
 		debugLogger.Debug("ListRepositories", "size", len(list))
+		list = c.Filter.apply(list)
+		c.checkpoint.prioritizeRepositories(list)
 		c.list = list
 		c.next = 0
 		newRepos := 0
@@ -48,232 +124,566 @@ func (c *Crawler) Tick() {
 			debugLogger.Debug("list", "id", idx, "name", repo.FullName, "created", repo.CreatedAt, "age", age)
 		}
 		logger.Info("ListRepositories", "size", len(list), "new", newRepos)
+		c.updateOrgWebHooks()
+	}
+	if len(c.list) == 0 {
+		return
+	}
+	budget, resource := c.budget()
+	if budget == 0 {
+		logger.Info("Rate limit budget exhausted, waiting for reset", "limit", c.client().limits.resource(resource))
+		return
+	}
+	end := min(c.next+c.Config.Schedule.batchSize(), len(c.list))
+	// completed repositories move to the front of the batch, the rest are retried next tick
+	batch := c.list[c.next:end]
+	completed, pending := c.crawlBatch(batch, budget)
+	copy(batch, append(completed, pending...))
+	c.next += len(completed)
+	if c.next >= len(c.list) {
+		c.next = 0
+		c.list = nil
 	}
-	if c.remaining < 2000 && !c.lowNotise {
-		logger.Info("Low Quota", "remaining", c.remaining)
-		c.lowNotise = true
-	} else {
-		c.lowNotise = false
-		repository := c.list[c.next]
-		prPageSize := ""
-		if c.Config.PRPageSize > 0 {
-			prPageSize = fmt.Sprintf("&per_page=%v", c.Config.PRPageSize)
-		}
-		URL := fmt.Sprintf("https://api.github.com/repos/%v/pulls?state=all%v", repository.FullName, prPageSize)
-		debugLogger.Debug("do getPullRequestsPage", "name", repository.FullName, "URL", URL)
-		pulls, _, err := c.getPullRequestsPage(URL)
-		if err != nil {
-			logger.Error("error getPullRequestsPage", "url", URL, "error", err)
-			return
-		}
-		for idx, pull := range pulls {
-			push := true
-			age := time.Since(pull.CreatedAt)
-			if pull.State == "closed" {
-				age = time.Since(*pull.ClosedAt)
-				if age.Hours() > 48 {
-					debugLogger.Debug("Not Pushing Old closed PR", "id", idx, "number", pull.Number, "title", pull.Title, "age", age)
-					push = false
-				}
-			}
-			if push {
-				debugLogger.Debug("Pushing PR", "id", idx, "number", pull.Number, "title", pull.Title)
-				event, err := pull.toPullRequestEvent()
-				if err != nil {
-					logger.Error("error converting PR to PullRequestEvent", "repo", repository.FullName, "id", idx, "number", pull.Number, "title", pull.Title)
-					continue
-				}
-				byteArray, err := event.parse()
-				if err != nil {
-					logger.Error("error parsing payload to json", "repo", repository.FullName, "id", idx, "number", pull.Number, "title", pull.Title, "error", err)
-				}
-				ctx := context.Background()
-				uuid := pull.generateUUID()
-				res, err := esapi.CreateRequest{
-					Index:      config.Elastic.Index,
-					DocumentID: uuid,
-					Body:       bytes.NewReader(byteArray),
-				}.Do(ctx, c.ES.esClient)
-				if err != nil {
-					logger.Error("error doing es request", "error", err)
-					continue
-				}
-				if res.IsError() {
-					if res.StatusCode == 409 {
-						debugLogger.Debug("Pushed PR - Already exists", "id", idx, "number", pull.Number, "uuid", uuid)
-						continue
-					}
-					printESError("error posting value", res)
-					continue
-				}
-				debugLogger.Debug("Pushed PR", "id", idx, "number", pull.Number, "uuid", uuid)
-				logger.Info("Pushed PR", "repo", repository.FullName, "number", pull.Number, "title", pull.Title, "state", pull.State, "age", age, "documentID", uuid)
-			}
+}
 
+// budget is the smallest budget of the resources a crawl spends, the graphql backend fetches pull requests
+// from the graphql resource and everything else from core
+func (c *Crawler) budget() (int, string) {
+	limits := c.client().limits
+	interval := c.Config.Schedule.interval()
+	budget, resource := limits.budget("core", interval, c.Config.RateLimit.Reserve), "core"
+	if c.Config.Backend == "graphql" {
+		if graphql := limits.budget("graphql", interval, c.Config.RateLimit.Reserve); graphql < budget {
+			budget, resource = graphql, "graphql"
 		}
+	}
+	return budget, resource
+}
 
-		//testHook := repository.CreatedAt.After(time.Now().Add(-48 * time.Hour))
-		err = c.updateWebHooks(repository.FullName)
-		if err != nil {
-			logger.Error("error updateWebHooks", "repository", repository.FullName, "error", err)
+// crawlBatch crawls repositories with a bounded number of workers sharing the client and its rate limit.
+// No more repositories are started once budget calls have been made. Returns the repositories that completed
+// and those that were not started or were stopped by a rate limit, in batch order
+func (c *Crawler) crawlBatch(repositories []Repository, budget int) ([]Repository, []Repository) {
+	limits := c.client().limits
+	start := limits.spent()
+	jobs := make(chan int)
+	done := make([]bool, len(repositories))
+	var wg sync.WaitGroup
+	for range min(c.Config.Schedule.workers(), len(repositories)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				done[idx] = c.crawlAndHook(repositories[idx])
+			}
+		}()
+	}
+	for idx := range repositories {
+		if limits.spent()-start >= budget || !limits.blockedUntil().IsZero() {
+			debugLogger.Debug("rate limit budget spent", "budget", budget, "started", idx)
+			break
+		}
+		if shuttingDown() {
+			break
 		}
-		if c.next+1 == len(c.list) {
-			c.next = 0
-			c.list = nil
+		jobs <- idx
+	}
+	close(jobs)
+	wg.Wait()
+	var completed, pending []Repository
+	for idx, repository := range repositories {
+		if done[idx] {
+			completed = append(completed, repository)
 		} else {
-			c.next += 1
+			pending = append(pending, repository)
 		}
 	}
+	return completed, pending
 }
 
-func (c *Crawler) ListRepositories() ([]Repository, error) {
-	//https://docs.github.com/en/rest/repos/repos?apiVersion=2022-11-28#list-repositories-for-the-authenticated-user
-	// Lists repositories that the authenticated user has explicit permission (:read, :write, or :admin) to access.
-	// The token must have the following permission set: metadata:read
-	debugLogger.Debug("ListRepositories start")
+// crawlAndHook tells if the repository is done for this cycle, it is not when the crawl was rate limited
+func (c *Crawler) crawlAndHook(repository Repository) bool {
+	err := c.crawlRepository(repository)
+	if errors.Is(err, ErrRateLimited) {
+		logger.Info("rate limited crawling repository, retrying after the reset", "repository", repository.FullName)
+		return false
+	}
+	if err != nil {
+		logger.Error("error crawling repository", "repository", repository.FullName, "error", err)
+		return true
+	}
+	err = c.updateWebHooks(repository.FullName)
+	if err != nil {
+		logger.Error("error updateWebHooks", "repository", repository.FullName, "error", err)
+	}
+	return true
+}
 
-	var r []Repository
-	next := "https://api.github.com/user/repos"
-	for next != "" {
-		page, nextURL, err := c.getRepositoriesPage(next)
+// crawlRepository crawls the configured event types of one repository and records progress in the checkpoint
+func (c *Crawler) crawlRepository(repository Repository) error {
+	c.mu.Lock()
+	progress := *c.checkpoint.repository(repository.FullName)
+	c.mu.Unlock()
+	if c.Config.crawlEvent("pull_request") || c.Config.crawlEvent("pull_request_review") {
+		crawlPullRequests := c.crawlPullRequests
+		if c.Config.Backend == "graphql" {
+			crawlPullRequests = c.crawlPullRequestsGraphQL
+		}
+		lastUpdatedAt, err := crawlPullRequests(repository, progress.LastUpdatedAt)
 		if err != nil {
-			logger.Error("error getting page", "page", next, "error", err)
-			return nil, err
+			return err
+		}
+		if lastUpdatedAt.After(progress.LastUpdatedAt) {
+			progress.LastUpdatedAt = lastUpdatedAt
 		}
+	}
+	if c.Config.crawlEvent("issues") && repository.HasIssues {
+		lastUpdatedAt, err := c.crawlIssues(repository, progress.LastIssueUpdatedAt)
+		if err != nil {
+			return err
+		}
+		if lastUpdatedAt.After(progress.LastIssueUpdatedAt) {
+			progress.LastIssueUpdatedAt = lastUpdatedAt
+		}
+	}
+	if c.Config.crawlEvent("workflow_run") || c.Config.crawlEvent("workflow_job") {
+		err := c.crawlWorkflowRuns(repository)
+		if err != nil {
+			return err
+		}
+	}
+	progress.LastCrawled = time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*c.checkpoint.repository(repository.FullName) = progress
+	c.saveCheckpoint()
+	return nil
+}
 
-		debugLogger.Debug("got page", "page", next, "size", len(page))
+// crawlPullRequests walks the pull requests of a repository, most recently updated first,
+// until they are older than the crawl window or not updated since lastSeen.
+// Returns where the next crawl starts, see resumeAt
+func (c *Crawler) crawlPullRequests(repository Repository, lastSeen time.Time) (time.Time, error) {
+	windowStart := time.Now().Add(-crawlWindow)
+	if lastSeen.After(windowStart) {
+		windowStart = lastSeen
+	}
+	newest := time.Time{}
+	failed := time.Time{}
+	next := c.pullRequestsURL(repository.FullName)
+	// Not modified on the first page only means nothing is new when the last crawl read every page it needed
+	// and queued everything, a held checkpoint or a crawl stopped by an error must fetch the pages again
+	finished := false
+	defer func(firstPage string) {
+		if !finished || !failed.IsZero() {
+			c.client().uncache(firstPage)
+		}
+	}(next)
+	idx := 0
+	for next != "" {
+		debugLogger.Debug("do getPullRequestsPage", "name", repository.FullName, "URL", next)
+		pulls, nextURL, err := c.getPullRequestsPage(next)
+		if err == ErrNotModified && idx == 0 {
+			debugLogger.Debug("pull requests not modified", "repo", repository.FullName)
+			finished = true
+			return newest, nil
+		}
+		if err != nil && err != ErrNotModified {
+			logger.Error("error getPullRequestsPage", "url", next, "error", err)
+			return newest, err
+		}
 		next = nextURL
-		r = append(r, page...)
+		for _, pull := range pulls {
+			if pull.UpdatedAt.Before(windowStart) {
+				debugLogger.Debug("Reached PRs outside crawl window", "repo", repository.FullName, "id", idx, "number", pull.Number, "updated", pull.UpdatedAt)
+				finished = true
+				return resumeAt(newest, failed), nil
+			}
+			if pull.UpdatedAt.After(newest) {
+				newest = pull.UpdatedAt
+			}
+			if c.Config.crawlEvent("pull_request") {
+				if err := c.pushPullRequest(repository, idx, pull); err != nil {
+					failed = pull.UpdatedAt
+				}
+			}
+			if c.Config.crawlEvent("pull_request_review") {
+				if err := c.crawlReviews(repository, pull); errors.Is(err, ErrRateLimited) {
+					return resumeAt(newest, failed), err
+				} else if err != nil {
+					failed = pull.UpdatedAt
+				}
+			}
+			idx += 1
+		}
 	}
-	return r, nil
+	finished = true
+	return resumeAt(newest, failed), nil
 }
-func (c *Crawler) getRepositoriesPage(url string) ([]Repository, string, error) {
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
+
+// pullRequestsURL is the first page crawlPullRequests reads
+func (c *Crawler) pullRequestsURL(fullName string) string {
+	prPageSize := ""
+	if c.Config.PRPageSize > 0 {
+		prPageSize = fmt.Sprintf("&per_page=%v", c.Config.PRPageSize)
+	}
+	return c.client().url("/repos/%v/pulls?state=all&sort=updated&direction=desc%v", fullName, prPageSize)
+}
+
+// resumeAt is where the next crawl of a repository starts, the newest updated_at seen unless something failed
+// to queue, then the updated_at of the oldest failure so it is crawled again
+func resumeAt(newest time.Time, failed time.Time) time.Time {
+	if failed.IsZero() {
+		return newest
+	}
+	return failed
+}
+
+func (c *Crawler) pushPullRequest(repository Repository, idx int, pull PullRequest) error {
+	age := time.Since(pull.CreatedAt)
+	if pull.State == "closed" {
+		age = time.Since(*pull.ClosedAt)
+		if age > crawlWindow {
+			debugLogger.Debug("Not Pushing Old closed PR", "id", idx, "number", pull.Number, "title", pull.Title, "age", age)
+			return nil
+		}
+	}
+	return c.indexPullRequest(repository, idx, pull, age)
+}
+
+// indexPullRequest queues a pull request without looking at its age, age is only logged. Only queuing errors
+// are returned, a pull request that cannot be converted is logged and skipped
+func (c *Crawler) indexPullRequest(repository Repository, idx int, pull PullRequest, age time.Duration) error {
+	debugLogger.Debug("Pushing PR", "id", idx, "number", pull.Number, "title", pull.Title)
+	event, err := pull.toPullRequestEvent()
 	if err != nil {
-		panic(err)
+		logger.Error("error converting PR to PullRequestEvent", "repo", repository.FullName, "id", idx, "number", pull.Number, "title", pull.Title)
+		return nil
 	}
-	req.Header = http.Header{
-		"Accept":               {"application/vnd.github+json"},
-		"X-GitHub-Api-Version": {"2022-11-28"},
-		"Authorization":        {"Bearer " + c.Config.Token},
+	byteArray, err := event.parse()
+	if err != nil {
+		logger.Error("error parsing payload to json", "repo", repository.FullName, "id", idx, "number", pull.Number, "title", pull.Title, "error", err)
 	}
-	resp, err := client.Do(req)
+	uuid := pull.generateUUID()
+	err = c.Sink.createEvent("pull_request", repository.FullName, uuid, event, byteArray, "repo", repository.FullName, "number", pull.Number, "title", pull.Title, "state", pull.State, "age", age)
 	if err != nil {
-		debugLogger.Debug("error doingRequest", "req", req)
-		return nil, "", err
+		logger.Error("error queuing document", "repo", repository.FullName, "number", pull.Number, "error", err)
+		return err
 	}
-	if resp.StatusCode == http.StatusUnauthorized {
-		return nil, "", ErrStatusUnauthorized
+	debugLogger.Debug("Queued PR", "id", idx, "number", pull.Number, "uuid", uuid)
+	return nil
+}
+
+// crawlReviews pushes the submitted reviews of a recently updated pull request. Returns the error reading
+// reviews or the last one queuing them
+func (c *Crawler) crawlReviews(repository Repository, pull PullRequest) error {
+	var queueErr error
+	next := c.client().url("/repos/%v/pulls/%v/reviews?per_page=100", repository.FullName, pull.Number)
+	for next != "" {
+		reviews, nextURL, err := c.getReviewsPage(next)
+		if err != nil {
+			logger.Error("error getReviewsPage", "url", next, "error", err)
+			return err
+		}
+		next = nextURL
+		for _, review := range reviews {
+			if err := c.pushReview(repository, pull, review); err != nil {
+				queueErr = err
+			}
+		}
 	}
-	bodyText, err := io.ReadAll(resp.Body)
-	if resp.StatusCode != http.StatusOK {
-		debugLogger.Debug("StatusError", "statusCode", resp.StatusCode, "body", bodyText)
-		return nil, "", ErrStatusNotAccepted
+	return queueErr
+}
+
+func (c *Crawler) pushReview(repository Repository, pull PullRequest, review PullRequestReview) error {
+	if review.SubmittedAt == nil {
+		debugLogger.Debug("Not Pushing pending review", "repo", repository.FullName, "number", pull.Number, "review", review.ID)
+		return nil
 	}
-	linkUnparsed := resp.Header.Get("Link")
-	links := linkheader.Parse(linkUnparsed)
-	nextLinks := links.FilterByRel("next")
-	debugLogger.Debug("ratelimit", "content", c.getRateLimits(resp.Header))
-	nextURL := ""
-	if len(nextLinks) > 0 {
-		nextURL = nextLinks[0].URL
+	event, err := review.toPullRequestReviewEvent(&pull)
+	if err != nil {
+		logger.Error("error converting review to PullRequestReviewEvent", "repo", repository.FullName, "number", pull.Number, "review", review.ID)
+		return nil
 	}
+	uuid := event.generateUUID()
+	byteArray, err := event.parse()
 	if err != nil {
-		logger.Error("error reading body", "error", err)
-		return nil, "", err
+		logger.Error("error parsing payload to json", "repo", repository.FullName, "number", pull.Number, "review", review.ID, "error", err)
+		return nil
 	}
-	var r []Repository
-	if err := json.Unmarshal(bodyText, &r); err != nil {
-		logger.Error("unable to unmarshal body", "body", bodyText)
-		return r, "", err
+	err = c.Sink.createEvent("pull_request_review", repository.FullName, uuid, event, byteArray, "repo", repository.FullName, "number", pull.Number, "review", review.ID, "reviewer", review.User.Login, "state", review.State)
+	if err != nil {
+		logger.Error("error queuing document", "repo", repository.FullName, "number", pull.Number, "review", review.ID, "error", err)
+		return err
 	}
+	debugLogger.Debug("Queued Review", "number", pull.Number, "review", review.ID, "uuid", uuid)
+	return nil
+}
+
+func (c *Crawler) getReviewsPage(url string) ([]PullRequestReview, string, error) {
+	var r []PullRequestReview
+	nextURL, err := c.client().get(url, &r)
 	return r, nextURL, err
 }
 
-func (c *Crawler) getRateLimits(header http.Header) *RateLimit {
-	used := convertVar(header, "X-Ratelimit-Used")
-	remaining := convertVar(header, "X-Ratelimit-Remaining")
-	c.remaining = *remaining
-	limit := convertVar(header, "X-Ratelimit-Limit")
-	var reset *time.Time
-	resetValue := header.Get("X-Ratelimit-Reset")
-	resetInt, err := strconv.ParseInt(resetValue, 10, 64)
-	if err != nil {
-		logger.Error("error ParseInt", "variable", "X-Ratelimit-Reset", "value", resetValue, "error", err)
-	} else {
-		resetTime := time.Unix(resetInt, 0)
-		reset = &resetTime
-	}
+// crawlWorkflowRuns pushes workflow runs created within the crawl window. A run is pushed again
+// when its status or attempt changes, so the created date is used instead of the checkpoint
+func (c *Crawler) crawlWorkflowRuns(repository Repository) error {
+	since := time.Now().Add(-crawlWindow).UTC().Format(time.RFC3339)
+	return c.crawlWorkflowRunsCreated(repository, "%3E"+since)
+}
 
-	ratelimit := &RateLimit{
-		Used:      used,
-		Remaining: remaining,
-		Total:     limit,
-		Reset:     reset,
+// crawlWorkflowRunsCreated pushes the runs matching a created search qualifier like >2025-01-01 or 2025-01-01..2025-02-01
+func (c *Crawler) crawlWorkflowRunsCreated(repository Repository, created string) error {
+	pageSize := ""
+	if c.Config.PRPageSize > 0 {
+		pageSize = fmt.Sprintf("&per_page=%v", c.Config.PRPageSize)
+	}
+	next := c.client().url("/repos/%v/actions/runs?created=%v%v", repository.FullName, created, pageSize)
+	return c.crawlWorkflowRunPages(repository, &next)
+}
+
+// crawlWorkflowRunPages pushes the runs from page on. Reading stops at a rate limit with page left at the page
+// to continue from, other errors are returned once all pages are done
+func (c *Crawler) crawlWorkflowRunPages(repository Repository, page *string) error {
+	var pushErr error
+	for *page != "" {
+		debugLogger.Debug("do getWorkflowRunsPage", "name", repository.FullName, "URL", *page)
+		runs, nextURL, err := c.getWorkflowRunsPage(*page)
+		if err != nil {
+			logger.Error("error getWorkflowRunsPage", "url", *page, "error", err)
+			return err
+		}
+		for _, run := range runs {
+			if c.Config.crawlEvent("workflow_run") {
+				if err := c.pushWorkflowRun(repository, run); err != nil {
+					pushErr = err
+				}
+			}
+			if c.Config.crawlEvent("workflow_job") && run.Status == "completed" {
+				if err := c.crawlWorkflowJobs(repository, run); errors.Is(err, ErrRateLimited) {
+					return err
+				} else if err != nil {
+					pushErr = err
+				}
+			}
+		}
+		*page = nextURL
 	}
-	ratelimit.setGauges()
-	return ratelimit
+	return pushErr
 }
 
-func convertVar(header http.Header, variable string) *int {
-	value := header.Get(variable)
-	res, err := strconv.Atoi(value)
+func (c *Crawler) pushWorkflowRun(repository Repository, run WorkflowRun) error {
+	event, err := run.toWorkflowRunEvent(repository)
+	if err != nil {
+		logger.Error("error converting run to WorkflowRunEvent", "repo", repository.FullName, "run", run.ID)
+		return nil
+	}
+	uuid := event.generateUUID()
+	byteArray, err := event.parse()
 	if err != nil {
-		logger.Error("error converting", "variable", variable, "value", value, "error", err)
+		logger.Error("error parsing payload to json", "repo", repository.FullName, "run", run.ID, "error", err)
 		return nil
+	}
+	err = c.Sink.createEvent("workflow_run", repository.FullName, uuid, event, byteArray, "repo", repository.FullName, "run", run.ID, "workflow", run.Name, "status", run.Status, "attempt", run.RunAttempt)
+	if err != nil {
+		logger.Error("error queuing document", "repo", repository.FullName, "run", run.ID, "error", err)
+		return err
+	}
+	debugLogger.Debug("Queued WorkflowRun", "repo", repository.FullName, "run", run.ID, "uuid", uuid)
+	return nil
+}
 
+// crawlWorkflowJobs pushes the jobs of a completed run. Returns the error reading jobs or the last one queuing them
+func (c *Crawler) crawlWorkflowJobs(repository Repository, run WorkflowRun) error {
+	var queueErr error
+	next := c.client().url("/repos/%v/actions/runs/%v/attempts/%v/jobs?per_page=100", repository.FullName, run.ID, run.RunAttempt)
+	for next != "" {
+		jobs, nextURL, err := c.getWorkflowJobsPage(next)
+		if err != nil {
+			logger.Error("error getWorkflowJobsPage", "url", next, "error", err)
+			return err
+		}
+		next = nextURL
+		for _, job := range jobs {
+			event, err := job.toWorkflowJobEvent(repository, run.TriggeringActor)
+			if err != nil {
+				logger.Error("error converting job to WorkflowJobEvent", "repo", repository.FullName, "job", job.ID)
+				continue
+			}
+			uuid := event.generateUUID()
+			byteArray, err := event.parse()
+			if err != nil {
+				logger.Error("error parsing payload to json", "repo", repository.FullName, "job", job.ID, "error", err)
+				continue
+			}
+			err = c.Sink.createEvent("workflow_job", repository.FullName, uuid, event, byteArray, "repo", repository.FullName, "run", run.ID, "job", job.ID, "name", job.Name, "status", job.Status)
+			if err != nil {
+				logger.Error("error queuing document", "repo", repository.FullName, "job", job.ID, "error", err)
+				queueErr = err
+				continue
+			}
+			debugLogger.Debug("Queued WorkflowJob", "repo", repository.FullName, "job", job.ID, "uuid", uuid)
+		}
 	}
-	return &res
+	return queueErr
 }
 
-type RateLimit struct {
-	Used      *int       `json:"used,omitempty"`
-	Remaining *int       `json:"remaining,omitempty"`
-	Total     *int       `json:"total,omitempty"`
-	Reset     *time.Time `json:"reset,omitempty"`
+// getWorkflowRunsPage is not cached, the created qualifier changes with every crawl
+func (c *Crawler) getWorkflowRunsPage(url string) ([]WorkflowRun, string, error) {
+	r := new(WorkflowRuns)
+	nextURL, err := c.client().getUncached(url, r)
+	return r.WorkflowRuns, nextURL, err
 }
 
-func (r *RateLimit) String() string {
-	return fmt.Sprintf("used: %v, remaining: %v, total: %v, resets: %v", *r.Used, *r.Remaining, *r.Total, r.Reset)
+func (c *Crawler) getWorkflowJobsPage(url string) ([]WorkflowJob, string, error) {
+	r := new(WorkflowJobs)
+	nextURL, err := c.client().get(url, r)
+	return r.Jobs, nextURL, err
+}
+
+// crawlIssues lists issues updated since the crawl window or lastSeen, whichever is later.
+// Issues backed by a pull request are skipped as they are covered by crawlPullRequests
+func (c *Crawler) crawlIssues(repository Repository, lastSeen time.Time) (time.Time, error) {
+	pageSize := ""
+	if c.Config.PRPageSize > 0 {
+		pageSize = fmt.Sprintf("&per_page=%v", c.Config.PRPageSize)
+	}
+	since := time.Now().Add(-crawlWindow)
+	// only a since taken from the checkpoint gives the same url again
+	cached := lastSeen.After(since)
+	if cached {
+		since = lastSeen
+	}
+	newest := time.Time{}
+	failed := time.Time{}
+	next := c.client().url("/repos/%v/issues?state=all&sort=updated&direction=desc&since=%v%v", repository.FullName, since.UTC().Format(time.RFC3339), pageSize)
+	idx := 0
+	for next != "" {
+		debugLogger.Debug("do getIssuesPage", "name", repository.FullName, "URL", next)
+		issues, nextURL, err := c.getIssuesPage(next, cached)
+		if err != nil {
+			logger.Error("error getIssuesPage", "url", next, "error", err)
+			return newest, err
+		}
+		next = nextURL
+		for _, issue := range issues {
+			if issue.UpdatedAt.After(newest) {
+				newest = issue.UpdatedAt
+			}
+			if issue.PullRequest != nil {
+				continue
+			}
+			if err := c.pushIssue(repository, idx, issue); err != nil {
+				failed = issue.UpdatedAt
+			}
+			idx += 1
+		}
+	}
+	return resumeAt(newest, failed), nil
+}
+
+func (c *Crawler) pushIssue(repository Repository, idx int, issue Issue) error {
+	age := time.Since(issue.CreatedAt)
+	if issue.State == "closed" && issue.ClosedAt != nil {
+		age = time.Since(*issue.ClosedAt)
+		if age > crawlWindow {
+			debugLogger.Debug("Not Pushing Old closed Issue", "id", idx, "number", issue.Number, "title", issue.Title, "age", age)
+			return nil
+		}
+	}
+	return c.indexIssue(repository, idx, issue, age)
 }
-func (r *RateLimit) setGauges() {
-	ratelimit_used.Set(float64(*r.Used))
-	ratelimit_remaining.Set(float64(*r.Remaining))
-	ratelimit_total.Set(float64(*r.Total))
-	ratelimit_reset.Set(float64(r.Reset.Unix() - time.Now().Unix()))
+
+// indexIssue queues an issue without looking at its age, age is only logged. Only queuing errors are returned
+func (c *Crawler) indexIssue(repository Repository, idx int, issue Issue, age time.Duration) error {
+	debugLogger.Debug("Pushing Issue", "id", idx, "number", issue.Number, "title", issue.Title)
+	event, err := issue.toIssuesEvent(repository)
+	if err != nil {
+		logger.Error("error converting Issue to IssuesEvent", "repo", repository.FullName, "id", idx, "number", issue.Number, "title", issue.Title)
+		return nil
+	}
+	uuid := event.generateUUID()
+	byteArray, err := event.parse()
+	if err != nil {
+		logger.Error("error parsing payload to json", "repo", repository.FullName, "id", idx, "number", issue.Number, "title", issue.Title, "error", err)
+		return nil
+	}
+	err = c.Sink.createEvent("issues", repository.FullName, uuid, event, byteArray, "repo", repository.FullName, "issue", issue.Number, "title", issue.Title, "state", issue.State, "age", age)
+	if err != nil {
+		logger.Error("error queuing document", "repo", repository.FullName, "issue", issue.Number, "error", err)
+		return err
+	}
+	debugLogger.Debug("Queued Issue", "id", idx, "number", issue.Number, "uuid", uuid)
+	return nil
 }
 
-type WebHook struct {
-	Type          *string       `json:"type,omitempty"`
-	ID            *int64        `json:"id,omitempty"`
-	Name          string        `json:"name,omitempty"`
-	Active        bool          `json:"active,omitempty"`
-	Events        *[]string     `json:"events,omitempty"`
-	Config        WebHookConfig `json:"config,omitempty"`
-	UpdatedAt     *time.Time    `json:"updated_at,omitempty"`
-	CreatedAt     *time.Time    `json:"created_at,omitempty"`
-	URL           *string       `json:"url,omitempty"`
-	TestURL       *string       `json:"test_url,omitempty"`
-	PingURL       *string       `json:"ping_url,omitempty"`
-	DeliveriesURL *string       `json:"deliveries_url,omitempty"`
-	LastResponse  *LastResponse `json:"last_response,omitempty"`
+func (c *Crawler) getIssuesPage(url string, cached bool) ([]Issue, string, error) {
+	var r []Issue
+	get := c.client().getUncached
+	if cached {
+		get = c.client().get
+	}
+	nextURL, err := get(url, &r)
+	return r, nextURL, err
 }
 
-func (webhook *WebHook) String() string {
-	return fmt.Sprintf("ID: %v, URL: %v, LastResponseCode: %v", *webhook.ID, webhook.Config.URL, webhook.LastResponse.Code)
+func (c *Crawler) ListRepositories() ([]Repository, error) {
+	//https://docs.github.com/en/rest/repos/repos?apiVersion=2022-11-28#list-repositories-for-the-authenticated-user
+	// Lists repositories that the authenticated user has explicit permission (:read, :write, or :admin) to access.
+	// The token must have the following permission set: metadata:read
+	debugLogger.Debug("ListRepositories start")
+	if c.client().app != nil {
+		return c.listInstallationRepositories()
+	}
+
+	var r []Repository
+	next := c.client().url("/user/repos")
+	for next != "" {
+		page, nextURL, err := c.getRepositoriesPage(next)
+		if err != nil {
+			logger.Error("error getting page", "page", next, "error", err)
+			return nil, err
+		}
+
+		debugLogger.Debug("got page", "page", next, "size", len(page))
+		next = nextURL
+		r = append(r, page...)
+	}
+	return r, nil
 }
 
-type LastResponse struct {
-	Code    int    `json:"code"`
-	Status  string `json:"status"`
-	Message string `json:"message"`
+// listInstallationRepositories lists the repositories of every installation of the github app
+func (c *Crawler) listInstallationRepositories() ([]Repository, error) {
+	//https://docs.github.com/en/rest/apps/installations?apiVersion=2022-11-28#list-repositories-accessible-to-the-app-installation
+	installations, err := c.client().app.listInstallations()
+	if err != nil {
+		logger.Error("error listing installations", "error", err)
+		return nil, err
+	}
+	var r []Repository
+	for _, installation := range installations {
+		client := c.client().withInstallation(installation.ID)
+		next := client.url("/installation/repositories")
+		for next != "" {
+			page := new(InstallationRepositories)
+			nextURL, err := client.get(next, page)
+			if err != nil {
+				logger.Error("error getting page", "installation", installation.ID, "page", next, "error", err)
+				return nil, err
+			}
+			debugLogger.Debug("got page", "installation", installation.ID, "account", installation.Account.Login, "page", next, "size", len(page.Repositories))
+			next = nextURL
+			r = append(r, page.Repositories...)
+		}
+	}
+	return r, nil
 }
 
-type WebHookConfig struct {
-	ContentType string  `json:"content_type"`
-	InsecureSSL *string `json:"insecure_ssl,omitempty"`
-	URL         string  `json:"url"`
+func (c *Crawler) getRepositoriesPage(url string) ([]Repository, string, error) {
+	var r []Repository
+	nextURL, err := c.client().get(url, &r)
+	return r, nextURL, err
 }
 
 /*
@@ -346,161 +756,7 @@ func (pr *PullRequest) toPullRequestEvent() (*PullRequestEvent, error) {
 }
 
 func (c *Crawler) getPullRequestsPage(url string) ([]PullRequest, string, error) {
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		panic(err)
-	}
-	req.Header = http.Header{
-		"Accept":               {"application/vnd.github+json"},
-		"X-GitHub-Api-Version": {"2022-11-28"},
-		"Authorization":        {"Bearer " + c.Config.Token},
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		debugLogger.Debug("error doingRequest", "req", req)
-		return nil, "", err
-	}
-
-	linkUnparsed := resp.Header.Get("Link")
-	links := linkheader.Parse(linkUnparsed)
-	nextLinks := links.FilterByRel("next")
-	debugLogger.Debug("ratelimit", "content", c.getRateLimits(resp.Header))
-	nextURL := ""
-	if len(nextLinks) > 0 {
-		nextURL = nextLinks[0].URL
-	}
-	bodyText, err := io.ReadAll(resp.Body)
-	if resp.StatusCode == http.StatusUnauthorized {
-		return nil, "", ErrStatusUnauthorized
-	}
-	if resp.StatusCode != http.StatusOK {
-		debugLogger.Debug("StatusError", "statusCode", resp.StatusCode, "body", bodyText)
-		return nil, "", ErrStatusNotAccepted
-	}
-	if err != nil {
-		logger.Error("error reading body", "error", err)
-		return nil, "", err
-	}
 	var r []PullRequest
-	if err := json.Unmarshal(bodyText, &r); err != nil {
-		logger.Error("unable to unmarshal body", "body", bodyText)
-		return r, "", err
-	}
-	return r, nextURL, err
-}
-
-func (c *Crawler) updateWebHooks(repoFullName string) error {
-	webhookURL := c.Config.getWebHookURL()
-	if webhookURL != "" {
-		hooksURL := fmt.Sprintf("https://api.github.com/repos/%v/hooks", repoFullName)
-		webhooks, _, err := c.getWebHooksPage(hooksURL)
-		if err != nil {
-			return err
-		}
-		newWebhookObject := WebHook{Name: "web", Active: true, Events: &[]string{"pull_request"}, Config: WebHookConfig{URL: c.Config.getWebHookURL(), ContentType: "json"}}
-		found := false
-		for _, webhook := range webhooks {
-			if webhook.Config.URL == newWebhookObject.Config.URL {
-				found = true
-				debugLogger.Debug("webhook already exists skipping", "repo-full-name", repoFullName)
-			}
-		}
-		if !found {
-			newWebhook, err := c.createWebHook(hooksURL, newWebhookObject)
-			if err != nil {
-				return err
-			}
-			debugLogger.Debug("webhook created: " + newWebhook.String())
-		}
-		return err
-	}
-	return nil
-}
-
-func (c *Crawler) getWebHooksPage(url string) ([]WebHook, string, error) {
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		panic(err)
-	}
-	req.Header = http.Header{
-		"Accept":               {"application/vnd.github+json"},
-		"X-GitHub-Api-Version": {"2022-11-28"},
-		"Authorization":        {"Bearer " + c.Config.Token},
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		debugLogger.Debug("error doingRequest", "req", req)
-		return nil, "", err
-	}
-
-	linkUnparsed := resp.Header.Get("Link")
-	links := linkheader.Parse(linkUnparsed)
-	nextLinks := links.FilterByRel("next")
-	debugLogger.Debug("ratelimit", "content", c.getRateLimits(resp.Header))
-	nextURL := ""
-	if len(nextLinks) > 0 {
-		nextURL = nextLinks[0].URL
-	}
-	bodyText, err := io.ReadAll(resp.Body)
-	if resp.StatusCode == http.StatusUnauthorized {
-		return nil, "", ErrStatusUnauthorized
-	}
-	if resp.StatusCode != http.StatusOK {
-		debugLogger.Debug("StatusError", "statusCode", resp.StatusCode, "body", bodyText)
-		return nil, "", ErrStatusNotAccepted
-	}
-	if err != nil {
-		logger.Error("error reading body", "error", err)
-		return nil, "", err
-	}
-	var r []WebHook
-	if err := json.Unmarshal(bodyText, &r); err != nil {
-		logger.Error("unable to unmarshal body", "body", bodyText)
-		return r, "", err
-	}
+	nextURL, err := c.client().getConditional(url, &r)
 	return r, nextURL, err
 }
-
-func (c *Crawler) createWebHook(url string, webhook WebHook) (*WebHook, error) {
-	client := &http.Client{}
-	marshalled, err := json.Marshal(webhook)
-	if err != nil {
-		logger.Error("Impossible to marshall Webhook", "error", err)
-		return nil, err
-	}
-	req, err := http.NewRequest("POST", url, bytes.NewReader(marshalled))
-	if err != nil {
-		panic(err)
-	}
-	req.Header = http.Header{
-		"Accept":               {"application/vnd.github+json"},
-		"X-GitHub-Api-Version": {"2022-11-28"},
-		"Authorization":        {"Bearer " + c.Config.Token},
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		debugLogger.Debug("error doingRequest", "req", req)
-		return nil, err
-	}
-	debugLogger.Debug("ratelimit", "content", c.getRateLimits(resp.Header))
-	bodyText, err := io.ReadAll(resp.Body)
-	if resp.StatusCode == http.StatusUnauthorized {
-		return nil, ErrStatusUnauthorized
-	}
-	if resp.StatusCode != http.StatusOK {
-		debugLogger.Debug("StatusError", "statusCode", resp.StatusCode, "body", bodyText)
-		return nil, ErrStatusNotAccepted
-	}
-	if err != nil {
-		logger.Error("error reading body", "error", err)
-		return nil, err
-	}
-	var r *WebHook
-	if err := json.Unmarshal(bodyText, r); err != nil {
-		logger.Error("unable to unmarshal body", "body", bodyText)
-		return r, err
-	}
-	return r, err
-}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 func Test_WebhookURL(t *testing.T) {
@@ -89,3 +93,240 @@ func Test_ListWebhooks(t *testing.T) {
 		t.Logf("[%v] %v", idx, webhook.String())
 	}
 }
+
+func Test_CrawlPullRequestsPagination(t *testing.T) {
+	setupTestlogging()
+	recent := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	closed := time.Now().Add(-72 * time.Hour).Format(time.RFC3339)
+	old := time.Now().Add(-96 * time.Hour).Format(time.RFC3339)
+	pages := []string{}
+	var server *httptest.Server
+	server = newTestGithubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("page")
+		pages = append(pages, page)
+		if r.URL.Query().Get("sort") != "updated" || r.URL.Query().Get("direction") != "desc" {
+			t.Errorf("pulls should be sorted by updated desc, got %v", r.URL.RawQuery)
+		}
+		switch page {
+		case "":
+			w.Header().Set("Link", fmt.Sprintf("<%v/repos/owner/repo/pulls?state=all&sort=updated&direction=desc&page=2>; rel=\"next\"", server.URL))
+			fmt.Fprintf(w, `[{"number":2,"state":"closed","updated_at":"%v","closed_at":"%v"}]`, recent, closed)
+		case "2":
+			w.Header().Set("Link", fmt.Sprintf("<%v/repos/owner/repo/pulls?state=all&sort=updated&direction=desc&page=3>; rel=\"next\"", server.URL))
+			fmt.Fprintf(w, `[{"number":1,"state":"closed","updated_at":"%v","closed_at":"%v"}]`, old, old)
+		default:
+			t.Errorf("page %v should not be requested", page)
+			w.Write([]byte(`[]`))
+		}
+	})
+	c := &Crawler{Config: ConfigGithub{APIURL: server.URL}}
+	_, err := c.crawlPullRequests(Repository{FullName: "owner/repo"}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 2 {
+		t.Errorf("expected 2 pages to be requested, got %v", pages)
+	}
+}
+
+func Test_CrawlPullRequestsResumesAfterRateLimit(t *testing.T) {
+	setupTestlogging()
+	recent := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	limited := true
+	pages := []string{}
+	var server *httptest.Server
+	server = newTestGithubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("page")
+		pages = append(pages, page)
+		switch page {
+		case "":
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Link", fmt.Sprintf("<%v/repos/owner/repo/pulls?state=all&sort=updated&direction=desc&page=2>; rel=\"next\"", server.URL))
+			fmt.Fprintf(w, `[{"id":2,"number":2,"state":"open","updated_at":"%v"}]`, recent)
+		case "2":
+			if limited {
+				limited = false
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			fmt.Fprintf(w, `[{"id":1,"number":1,"state":"open","updated_at":"%v"}]`, recent)
+		}
+	})
+	sink := &recordingSink{}
+	c := &Crawler{Config: ConfigGithub{APIURL: server.URL, Cache: ConfigCache{Enabled: true}}, Sink: sink}
+	if _, err := c.crawlPullRequests(Repository{FullName: "owner/repo"}, time.Time{}); err != ErrRateLimited {
+		t.Fatalf("first crawl should be %v, got %v", ErrRateLimited, err)
+	}
+	if len(sink.events) != 1 {
+		t.Fatalf("first page should be queued, got %v", sink.events)
+	}
+	if _, err := c.crawlPullRequests(Repository{FullName: "owner/repo"}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.events) != 3 || pages[len(pages)-1] != "2" {
+		t.Errorf("resumed crawl should read every page again, got events %v pages %v", sink.events, pages)
+	}
+	if _, err := c.crawlPullRequests(Repository{FullName: "owner/repo"}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.events) != 3 || pages[len(pages)-1] != "" {
+		t.Errorf("finished crawl should be answered with not modified, got events %v pages %v", sink.events, pages)
+	}
+}
+
+func Test_CrawlIssuesSkipsPullRequests(t *testing.T) {
+	setupTestlogging()
+	closed := time.Now().Add(-72 * time.Hour).Format(time.RFC3339)
+	recent := time.Now().Add(-time.Hour)
+	server := newTestGithubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/owner/repo/issues" {
+			t.Errorf("unexpected path %v", r.URL.Path)
+		}
+		if r.URL.Query().Get("since") == "" {
+			t.Errorf("issues should be requested with since, got %v", r.URL.RawQuery)
+		}
+		fmt.Fprintf(w, `[{"number":2,"state":"open","updated_at":"%v","pull_request":{"url":"x"}},{"number":1,"state":"closed","updated_at":"%v","closed_at":"%v"}]`,
+			recent.Format(time.RFC3339), recent.Add(-time.Minute).Format(time.RFC3339), closed)
+	})
+	c := &Crawler{Config: ConfigGithub{APIURL: server.URL}}
+	newest, err := c.crawlIssues(Repository{FullName: "owner/repo"}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !newest.Equal(recent.Truncate(time.Second)) {
+		t.Errorf("%v should be %v", newest, recent.Truncate(time.Second))
+	}
+}
+
+func Test_CrawlIssuesHoldsFailedCheckpoint(t *testing.T) {
+	setupTestlogging()
+	recent := time.Now().Add(-time.Hour).Truncate(time.Second)
+	failed := recent.Add(-time.Minute)
+	server := newTestGithubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `[{"number":2,"state":"open","updated_at":"%v"},{"number":1,"state":"open","updated_at":"%v"}]`,
+			recent.Format(time.RFC3339), failed.Format(time.RFC3339))
+	})
+	sink := &recordingSink{err: ErrQueueFull}
+	c := &Crawler{Config: ConfigGithub{APIURL: server.URL}, Sink: sink}
+	resume, err := c.crawlIssues(Repository{FullName: "owner/repo"}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resume.Equal(failed) {
+		t.Errorf("%v should be held at the oldest failed issue %v", resume, failed)
+	}
+	if len(sink.events) != 2 {
+		t.Errorf("both issues should be tried, got %v", sink.events)
+	}
+}
+
+func Test_CrawlReviews(t *testing.T) {
+	setupTestlogging()
+	server := newTestGithubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/owner/repo/pulls/7/reviews" {
+			t.Errorf("unexpected path %v", r.URL.Path)
+		}
+		w.Write([]byte(`[{"id":1,"state":"APPROVED","submitted_at":"2025-01-01T10:00:00Z","commit_id":"abc","user":{"login":"reviewer"}},{"id":2,"state":"PENDING","user":{"login":"other"}}]`))
+	})
+	search := newTestSearch(t, func(documentID string) int { return http.StatusCreated })
+	created := documentCount(t, "created")
+	c := &Crawler{Config: ConfigGithub{APIURL: server.URL}, Sink: search}
+	if err := c.crawlReviews(Repository{FullName: "owner/repo"}, PullRequest{Number: 7, Base: Reference{Repo: Repository{FullName: "owner/repo"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := search.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if documentCount(t, "created")-created != 1 {
+		t.Errorf("only the submitted review should be pushed")
+	}
+}
+
+func Test_TickCrawlsBatch(t *testing.T) {
+	setupTestlogging()
+	server := newTestGithubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/user/repos" {
+			w.Write([]byte(`[{"full_name":"owner/one"},{"full_name":"owner/two"},{"full_name":"owner/three"}]`))
+			return
+		}
+		w.Write([]byte(`[]`))
+	})
+	c := &Crawler{Config: ConfigGithub{APIURL: server.URL, Schedule: ConfigSchedule{BatchSize: 2, Workers: 2}}}
+	c.Tick()
+	if c.next != 2 {
+		t.Errorf("next should be 2 after one batch, got %v", c.next)
+	}
+	crawled := 0
+	for _, repository := range c.checkpoint.Repositories {
+		if !repository.LastCrawled.IsZero() {
+			crawled += 1
+		}
+	}
+	if crawled != 2 {
+		t.Errorf("expected 2 repositories crawled, got %v", crawled)
+	}
+	c.Tick()
+	if c.list != nil || c.next != 0 {
+		t.Errorf("list should be reset after the last batch, got next %v", c.next)
+	}
+}
+
+func Test_TickRetriesRateLimitedRepository(t *testing.T) {
+	setupTestlogging()
+	server := newTestGithubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/user/repos":
+			w.Write([]byte(`[{"full_name":"owner/one"},{"full_name":"owner/two"},{"full_name":"owner/three"}]`))
+		case "/repos/owner/one/pulls":
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Write([]byte(`[]`))
+		}
+	})
+	c := &Crawler{Config: ConfigGithub{APIURL: server.URL, Schedule: ConfigSchedule{BatchSize: 2, Workers: 1}}}
+	c.Tick()
+	if c.next != 1 {
+		t.Errorf("only the completed repository should be passed, next %v", c.next)
+	}
+	if c.list[0].FullName != "owner/two" || c.list[1].FullName != "owner/one" {
+		t.Errorf("rate limited repository should be next, got %v %v", c.list[0].FullName, c.list[1].FullName)
+	}
+}
+
+func Test_HoldFailedRepositories(t *testing.T) {
+	setupTestlogging()
+	server := newTestGithubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(`[]`))
+	})
+	sink := &recordingSink{}
+	c := &Crawler{Config: ConfigGithub{APIURL: server.URL, Cache: ConfigCache{Enabled: true}}, Sink: sink, checkpoint: NewCheckpoint()}
+	if _, _, err := c.getPullRequestsPage(c.pullRequestsURL("owner/repo")); err != nil {
+		t.Fatal(err)
+	}
+	crawled := time.Now().Add(-time.Hour)
+	*c.checkpoint.repository("owner/repo") = RepositoryCheckpoint{LastCrawled: crawled, LastUpdatedAt: crawled, LastIssueUpdatedAt: crawled}
+	*c.checkpoint.repository("owner/other") = RepositoryCheckpoint{LastCrawled: crawled, LastUpdatedAt: crawled}
+	sink.failed = []string{"owner/repo"}
+	c.holdFailedRepositories()
+	progress := c.checkpoint.repository("owner/repo")
+	if !progress.LastUpdatedAt.IsZero() || !progress.LastIssueUpdatedAt.IsZero() {
+		t.Errorf("failed repository should be crawled from the start of the window, got %+v", progress)
+	}
+	if !c.checkpoint.repository("owner/other").LastUpdatedAt.Equal(crawled) {
+		t.Errorf("other repositories should keep their checkpoint")
+	}
+	if _, _, err := c.getPullRequestsPage(c.pullRequestsURL("owner/repo")); err != nil {
+		t.Errorf("first page should be fetched without validators, got %v", err)
+	}
+}
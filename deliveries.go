@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	github_webhook_failing = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "github_webhook_failing",
+		Help: "1 when the managed hook has a failing last response or undelivered events within the lookback",
+	}, []string{"target"})
+	github_webhook_failed_deliveries = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "github_webhook_failed_deliveries",
+		Help: "Deliveries within the lookback that never succeeded",
+	}, []string{"target"})
+	github_webhook_redeliveries = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "github_webhook_redeliveries_total",
+		Help: "Redeliveries requested for failed webhook deliveries",
+	})
+)
+
+// HookDelivery is one entry of /hooks/{id}/deliveries, redeliveries share the GUID of the original delivery
+type HookDelivery struct {
+	ID          int64     `json:"id"`
+	GUID        string    `json:"guid"`
+	DeliveredAt time.Time `json:"delivered_at"`
+	Redelivery  bool      `json:"redelivery"`
+	Status      string    `json:"status"`
+	StatusCode  int       `json:"status_code"`
+	Event       string    `json:"event"`
+	Action      *string   `json:"action"`
+}
+
+func (d *HookDelivery) failed() bool {
+	return d.StatusCode < 200 || d.StatusCode > 299
+}
+
+func (r *LastResponse) failed() bool {
+	// code is null until the hook has been used
+	return r != nil && r.Code != 0 && (r.Code < 200 || r.Code > 299)
+}
+
+// monitorWebHook exports the health of a hook and redelivers failed deliveries when enabled
+func (c *Crawler) monitorWebHook(hookURL string, target string, webhook WebHook) error {
+	if webhook.LastResponse.failed() {
+		logger.Warn("webhook failing", "target", target, "id", *webhook.ID, "code", webhook.LastResponse.Code, "message", webhook.LastResponse.Message)
+	}
+	lookback := c.Config.Webhook.RedeliverLookback
+	if lookback == 0 {
+		lookback = time.Hour
+	}
+	deliveries, err := c.listDeliveries(hookURL, time.Now().Add(-lookback))
+	if err != nil {
+		return err
+	}
+	failed := failedDeliveries(deliveries)
+	github_webhook_failed_deliveries.WithLabelValues(target).Set(float64(len(failed)))
+	if webhook.LastResponse.failed() || len(failed) > 0 {
+		github_webhook_failing.WithLabelValues(target).Set(1)
+	} else {
+		github_webhook_failing.WithLabelValues(target).Set(0)
+	}
+	if !c.Config.Webhook.Redeliver {
+		return nil
+	}
+	for _, delivery := range failed {
+		// only one redelivery per event, a failing redelivery is left for the gauges to show
+		if delivery.Redelivery {
+			continue
+		}
+		_, err := c.client().do("POST", fmt.Sprintf("%v/deliveries/%v/attempts", hookURL, delivery.ID), nil, nil)
+		if err != nil {
+			return err
+		}
+		github_webhook_redeliveries.Inc()
+		logger.Info("webhook redelivery requested", "target", target, "delivery", delivery.GUID, "event", delivery.Event, "statusCode", delivery.StatusCode)
+	}
+	return nil
+}
+
+// listDeliveries reads deliveries, newest first, until one is older than since
+func (c *Crawler) listDeliveries(hookURL string, since time.Time) ([]HookDelivery, error) {
+	var r []HookDelivery
+	next := hookURL + "/deliveries?per_page=100"
+	for next != "" {
+		page, nextURL, err := c.getDeliveriesPage(next)
+		if err != nil {
+			return nil, err
+		}
+		next = nextURL
+		for _, delivery := range page {
+			if delivery.DeliveredAt.Before(since) {
+				return r, nil
+			}
+			r = append(r, delivery)
+		}
+	}
+	return r, nil
+}
+
+func (c *Crawler) getDeliveriesPage(url string) ([]HookDelivery, string, error) {
+	var r []HookDelivery
+	nextURL, err := c.client().get(url, &r)
+	return r, nextURL, err
+}
+
+// failedDeliveries returns the latest attempt of each delivery that never succeeded
+func failedDeliveries(deliveries []HookDelivery) []HookDelivery {
+	succeeded := make(map[string]bool)
+	for _, delivery := range deliveries {
+		if !delivery.failed() {
+			succeeded[delivery.GUID] = true
+		}
+	}
+	seen := make(map[string]bool)
+	var r []HookDelivery
+	for _, delivery := range deliveries {
+		if succeeded[delivery.GUID] || seen[delivery.GUID] {
+			continue
+		}
+		seen[delivery.GUID] = true
+		r = append(r, delivery)
+	}
+	return r
+}
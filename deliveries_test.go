@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_FailedDeliveries(t *testing.T) {
+	deliveries := []HookDelivery{
+		{ID: 4, GUID: "a", StatusCode: 200, Redelivery: true},
+		{ID: 3, GUID: "b", StatusCode: 502, Redelivery: true},
+		{ID: 2, GUID: "a", StatusCode: 500},
+		{ID: 1, GUID: "b", StatusCode: 0},
+		{ID: 0, GUID: "c", StatusCode: 500},
+	}
+	failed := failedDeliveries(deliveries)
+	if len(failed) != 2 || failed[0].ID != 3 || failed[1].ID != 0 {
+		t.Errorf("expected latest attempts of b and c, got %+v", failed)
+	}
+}
+
+func Test_MonitorWebHookRedelivers(t *testing.T) {
+	setupTestlogging()
+	recent := time.Now().Add(-time.Minute).Format(time.RFC3339)
+	old := time.Now().Add(-2 * time.Hour).Format(time.RFC3339)
+	redelivered := []string{}
+	server := newTestGithubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/owner/repo/hooks/1/deliveries":
+			fmt.Fprintf(w, `[{"id":12,"guid":"new","delivered_at":"%v","status_code":500},{"id":11,"guid":"done","delivered_at":"%v","status_code":200},{"id":10,"guid":"old","delivered_at":"%v","status_code":500}]`, recent, recent, old)
+		case r.Method == http.MethodPost:
+			redelivered = append(redelivered, r.URL.Path)
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			t.Errorf("unexpected %v %v", r.Method, r.URL)
+		}
+	})
+	c := &Crawler{Config: ConfigGithub{APIURL: server.URL}}
+	c.Config.Webhook.Redeliver = true
+	id := int64(1)
+	webhook := WebHook{ID: &id, LastResponse: &LastResponse{Code: 500}}
+	if err := c.monitorWebHook(server.URL+"/repos/owner/repo/hooks/1", "owner/repo", webhook); err != nil {
+		t.Fatal(err)
+	}
+	if len(redelivered) != 1 || redelivered[0] != "/repos/owner/repo/hooks/1/deliveries/12/attempts" {
+		t.Errorf("only the recent failed delivery should be redelivered, got %v", redelivered)
+	}
+}
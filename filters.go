@@ -0,0 +1,121 @@
+package main
+
+import (
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+type ConfigRepositories struct {
+	// Owners limits crawling to repositories of these users or organizations
+	Owners []string `mapstructure:"owners"`
+	// Include and Exclude are globs matched against the full name, like owner/*
+	Include      []string `mapstructure:"include"`
+	Exclude      []string `mapstructure:"exclude"`
+	IncludeRegex []string `mapstructure:"include_regex"`
+	ExcludeRegex []string `mapstructure:"exclude_regex"`
+	// Topics keeps repositories with at least one of the topics
+	Topics        []string `mapstructure:"topics"`
+	ExcludeTopics []string `mapstructure:"exclude_topics"`
+	SkipForks     bool     `mapstructure:"skip_forks"`
+	SkipArchived  bool     `mapstructure:"skip_archived"`
+	SkipDisabled  bool     `mapstructure:"skip_disabled"`
+	SkipTemplates bool     `mapstructure:"skip_templates"`
+}
+
+// RepositoryFilter is ConfigRepositories with the expressions compiled
+type RepositoryFilter struct {
+	config       ConfigRepositories
+	includeRegex []*regexp.Regexp
+	excludeRegex []*regexp.Regexp
+}
+
+func NewRepositoryFilter(config ConfigRepositories) (*RepositoryFilter, error) {
+	f := &RepositoryFilter{config: config}
+	for _, glob := range append(append([]string{}, config.Include...), config.Exclude...) {
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, err
+		}
+	}
+	for _, expr := range config.IncludeRegex {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		f.includeRegex = append(f.includeRegex, re)
+	}
+	for _, expr := range config.ExcludeRegex {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		f.excludeRegex = append(f.excludeRegex, re)
+	}
+	return f, nil
+}
+
+// apply returns the repositories passing the filter, a nil filter keeps everything
+func (f *RepositoryFilter) apply(list []Repository) []Repository {
+	if f == nil {
+		return list
+	}
+	r := make([]Repository, 0, len(list))
+	for _, repository := range list {
+		if reason := f.skip(repository); reason != "" {
+			debugLogger.Debug("repository filtered", "repository", repository.FullName, "reason", reason)
+			continue
+		}
+		r = append(r, repository)
+	}
+	return r
+}
+
+// skip gives the reason a repository is filtered out or an empty string to keep it
+func (f *RepositoryFilter) skip(repository Repository) string {
+	switch {
+	case f.config.SkipForks && repository.Fork:
+		return "fork"
+	case f.config.SkipArchived && repository.Archived:
+		return "archived"
+	case f.config.SkipDisabled && repository.Disabled:
+		return "disabled"
+	case f.config.SkipTemplates && repository.IsTemplate:
+		return "template"
+	}
+	owner, _, _ := strings.Cut(repository.FullName, "/")
+	if len(f.config.Owners) > 0 && !slices.ContainsFunc(f.config.Owners, func(o string) bool { return strings.EqualFold(o, owner) }) {
+		return "owner"
+	}
+	included := len(f.config.Include) == 0 && len(f.includeRegex) == 0
+	for _, glob := range f.config.Include {
+		if matched, _ := path.Match(glob, repository.FullName); matched {
+			included = true
+		}
+	}
+	for _, re := range f.includeRegex {
+		if re.MatchString(repository.FullName) {
+			included = true
+		}
+	}
+	if !included {
+		return "include"
+	}
+	for _, glob := range f.config.Exclude {
+		if matched, _ := path.Match(glob, repository.FullName); matched {
+			return "exclude"
+		}
+	}
+	for _, re := range f.excludeRegex {
+		if re.MatchString(repository.FullName) {
+			return "exclude"
+		}
+	}
+	if len(f.config.Topics) > 0 && !slices.ContainsFunc(repository.Topics, func(t string) bool { return slices.Contains(f.config.Topics, t) }) {
+		return "topics"
+	}
+	if slices.ContainsFunc(repository.Topics, func(t string) bool { return slices.Contains(f.config.ExcludeTopics, t) }) {
+		return "exclude_topics"
+	}
+	return ""
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_RepositoryFilter(t *testing.T) {
+	setupTestlogging()
+	filter, err := NewRepositoryFilter(ConfigRepositories{
+		Owners:        []string{"Owner"},
+		Include:       []string{"owner/*"},
+		ExcludeRegex:  []string{`-old$`},
+		ExcludeTopics: []string{"deprecated"},
+		SkipForks:     true,
+		SkipArchived:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := []Repository{
+		{FullName: "owner/keep"},
+		{FullName: "owner/fork", Fork: true},
+		{FullName: "owner/archived", Archived: true},
+		{FullName: "other/repo"},
+		{FullName: "owner/service-old"},
+		{FullName: "owner/deprecated", Topics: []string{"deprecated"}},
+	}
+	kept := filter.apply(list)
+	if len(kept) != 1 || kept[0].FullName != "owner/keep" {
+		t.Errorf("only owner/keep should pass, got %v", kept)
+	}
+	if _, err := NewRepositoryFilter(ConfigRepositories{IncludeRegex: []string{"("}}); err == nil {
+		t.Errorf("invalid regex should fail")
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/tomnomnom/linkheader"
+)
+
+const (
+	defaultAPIURL     = "https://api.github.com"
+	defaultUploadURL  = "https://uploads.github.com"
+	defaultGraphQLURL = "https://api.github.com/graphql"
+	githubAPIVersion  = "2022-11-28"
+)
+
+// GithubClient does all requests against the GitHub REST API.
+// For GitHub Enterprise Server the api url is usually https://HOSTNAME/api/v3
+type GithubClient struct {
+	APIURL     string
+	UploadURL  string
+	GraphQLURL string
+	Token      string
+	httpClient *http.Client
+	limits     *rateLimits
+	cache      *responseCache
+	app        *GithubApp
+	auth       authorizer
+}
+
+type authorizer interface {
+	authorization(url string) (string, error)
+}
+
+func NewGithubClient(config ConfigGithub) *GithubClient {
+	client := &GithubClient{
+		APIURL:     strings.TrimSuffix(config.APIURL, "/"),
+		UploadURL:  strings.TrimSuffix(config.UploadURL, "/"),
+		GraphQLURL: config.GraphQLURL,
+		Token:      config.Token,
+		httpClient: &http.Client{},
+		limits:     newRateLimits(config.RateLimit.SecondaryBackoff),
+		cache:      newResponseCache(config.Cache),
+	}
+	if client.APIURL == "" {
+		client.APIURL = defaultAPIURL
+	}
+	if client.UploadURL == "" {
+		client.UploadURL = defaultUploadURL
+	}
+	if client.GraphQLURL == "" {
+		client.GraphQLURL = defaultGraphQLURL
+	}
+	if config.AppID != 0 {
+		privateKey, err := config.readPrivateKey()
+		if err != nil {
+			logger.Error("error reading github app private key", "error", err)
+			os.Exit(1)
+		}
+		client.app = NewGithubApp(config.AppID, privateKey, client.APIURL)
+		client.auth = &appAuthorizer{app: client.app, apiURL: client.APIURL}
+	}
+	return client
+}
+
+// withInstallation returns a client that always uses the token of one app installation
+func (g *GithubClient) withInstallation(installationID int64) *GithubClient {
+	client := *g
+	client.auth = &installationAuthorizer{app: g.app, installationID: installationID}
+	return &client
+}
+
+func (g *GithubClient) authorization(url string) (string, error) {
+	if g.auth == nil {
+		return g.Token, nil
+	}
+	return g.auth.authorization(url)
+}
+
+// cacheKey is url prefixed by who the request is sent as, so a response is never served to another token
+func (g *GithubClient) cacheKey(url string) string {
+	switch auth := g.auth.(type) {
+	case *installationAuthorizer:
+		return fmt.Sprintf("installation/%v %v", auth.installationID, url)
+	case *appAuthorizer:
+		return fmt.Sprintf("app/%v %v", auth.app.AppID, url)
+	case *appJWTAuthorizer:
+		return fmt.Sprintf("jwt/%v %v", auth.app.AppID, url)
+	}
+	sum := sha256.Sum256([]byte(g.Token))
+	return fmt.Sprintf("token/%v %v", hex.EncodeToString(sum[:8]), url)
+}
+
+// uncache drops the cached response for url, the next request for it is sent without validators
+func (g *GithubClient) uncache(url string) {
+	g.cache.remove(g.cacheKey(url))
+}
+
+// url builds an absolute api url from a path like "/repos/%v/hooks"
+func (g *GithubClient) url(format string, a ...interface{}) string {
+	return g.APIURL + fmt.Sprintf(format, a...)
+}
+
+// get decodes the response into v, unchanged responses are served from the cache
+func (g *GithubClient) get(url string, v interface{}) (string, error) {
+	nextURL, err := g.do("GET", url, nil, v)
+	if err == ErrNotModified {
+		return nextURL, nil
+	}
+	return nextURL, err
+}
+
+// getUncached is get without the cache, for urls built from the current time that are never requested again
+func (g *GithubClient) getUncached(url string, v interface{}) (string, error) {
+	return g.request("GET", url, nil, v, false)
+}
+
+// getConditional is get returning ErrNotModified, with v filled from the cache, when the response is unchanged
+func (g *GithubClient) getConditional(url string, v interface{}) (string, error) {
+	return g.do("GET", url, nil, v)
+}
+
+// do sends body as json and decodes the response into v. Returns the rel=next link if there is one
+func (g *GithubClient) do(method string, url string, body interface{}, v interface{}) (string, error) {
+	return g.request(method, url, body, v, method == "GET")
+}
+
+// request is do, validating against and storing in the cache when cached is set
+func (g *GithubClient) request(method string, url string, body interface{}, v interface{}, cached bool) (string, error) {
+	var reader io.Reader
+	if body != nil {
+		marshalled, err := json.Marshal(body)
+		if err != nil {
+			logger.Error("Impossible to marshall body", "error", err)
+			return "", err
+		}
+		reader = bytes.NewReader(marshalled)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return "", err
+	}
+	if until := g.limits.blockedUntil(); !until.IsZero() {
+		debugLogger.Debug("rate limited, not sending request", "method", method, "url", url, "until", until)
+		return "", ErrRateLimited
+	}
+	token, err := g.authorization(url)
+	if err != nil {
+		return "", err
+	}
+	req.Header = http.Header{
+		"Accept":               {"application/vnd.github+json"},
+		"X-GitHub-Api-Version": {githubAPIVersion},
+		"Authorization":        {"Bearer " + token},
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	var entry *cacheEntry
+	if cached {
+		entry = g.cache.get(g.cacheKey(url))
+	}
+	if entry != nil {
+		if entry.ETag != "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		debugLogger.Debug("error doingRequest", "method", method, "url", url)
+		return "", err
+	}
+	defer resp.Body.Close()
+	ratelimit := g.getRateLimits(resp.Header, resp.StatusCode)
+	debugLogger.Debug("ratelimit", "content", ratelimit)
+	bodyText, err := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusUnauthorized {
+		return "", ErrStatusUnauthorized
+	}
+	if rateLimited(resp.StatusCode, resp.Header, bodyText) {
+		until := g.limits.block(resp.Header, ratelimit)
+		logger.Warn("rate limited by github", "method", method, "url", url, "statusCode", resp.StatusCode, "resource", ratelimit.Resource, "until", until)
+		return "", ErrRateLimited
+	}
+	if resp.StatusCode == http.StatusNotModified && entry != nil {
+		github_cache_requests.WithLabelValues("not_modified").Inc()
+		debugLogger.Debug("not modified", "url", url)
+		if v != nil && len(entry.Body) > 0 {
+			if err := json.Unmarshal(entry.Body, v); err != nil {
+				return "", err
+			}
+		}
+		return entry.NextURL, ErrNotModified
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		debugLogger.Debug("StatusError", "method", method, "url", url, "statusCode", resp.StatusCode, "body", bodyText)
+		switch resp.StatusCode {
+		case http.StatusForbidden:
+			return "", ErrStatusForbidden
+		case http.StatusNotFound:
+			return "", ErrStatusNotFound
+		}
+		return "", ErrStatusNotAccepted
+	}
+	if err != nil {
+		logger.Error("error reading body", "error", err)
+		return "", err
+	}
+	nextURL := ""
+	nextLinks := linkheader.Parse(resp.Header.Get("Link")).FilterByRel("next")
+	if len(nextLinks) > 0 {
+		nextURL = nextLinks[0].URL
+	}
+	if entry != nil {
+		github_cache_requests.WithLabelValues("modified").Inc()
+	}
+	if cached && (resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != "") {
+		g.cache.put(&cacheEntry{Key: g.cacheKey(url), URL: url, ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified"), NextURL: nextURL, Body: bodyText})
+	}
+	if v == nil || len(bodyText) == 0 {
+		return nextURL, nil
+	}
+	if err := json.Unmarshal(bodyText, v); err != nil {
+		logger.Error("unable to unmarshal body", "body", bodyText)
+		return "", err
+	}
+	return nextURL, nil
+}
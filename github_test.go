@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestGithubServer(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Ratelimit-Used", "1")
+		w.Header().Set("X-Ratelimit-Remaining", "4999")
+		w.Header().Set("X-Ratelimit-Limit", "5000")
+		w.Header().Set("X-Ratelimit-Reset", "1700000000")
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func Test_GithubClientBaseURL(t *testing.T) {
+	setupTestlogging()
+	client := NewGithubClient(ConfigGithub{})
+	if client.APIURL != defaultAPIURL {
+		t.Errorf("%v should be %v", client.APIURL, defaultAPIURL)
+	}
+	client = NewGithubClient(ConfigGithub{APIURL: "https://github.example.com/api/v3/"})
+	wanted := "https://github.example.com/api/v3/repos/owner/repo/hooks"
+	if url := client.url("/repos/%v/hooks", "owner/repo"); url != wanted {
+		t.Errorf("%v should be %v", url, wanted)
+	}
+}
+
+func Test_ListRepositoriesStandIn(t *testing.T) {
+	setupTestlogging()
+	var server *httptest.Server
+	server = newTestGithubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/user/repos" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Query().Get("page") == "" {
+			w.Header().Set("Link", fmt.Sprintf("<%v/user/repos?page=2>; rel=\"next\"", server.URL))
+			w.Write([]byte(`[{"id":1,"full_name":"owner/one"}]`))
+			return
+		}
+		w.Write([]byte(`[{"id":2,"full_name":"owner/two"}]`))
+	})
+	c := &Crawler{Config: ConfigGithub{APIURL: server.URL, Token: "test-token"}}
+	repos, err := c.ListRepositories()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repos) != 2 || repos[1].FullName != "owner/two" {
+		t.Errorf("expected both pages, got %v", repos)
+	}
+
+	c = &Crawler{Config: ConfigGithub{APIURL: server.URL, Token: "wrong"}}
+	_, err = c.ListRepositories()
+	if err != ErrStatusUnauthorized {
+		t.Errorf("%v should be %v", err, ErrStatusUnauthorized)
+	}
+}
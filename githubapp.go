@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// GitHub allows at most 10 minutes, iat is backdated to allow for clock drift
+	appJWTLifetime = 9 * time.Minute
+	appJWTBackdate = 60 * time.Second
+	// installation tokens live for an hour, refresh a bit before they run out
+	installationTokenRefresh = 5 * time.Minute
+)
+
+var (
+	ErrNoInstallation = errors.New("error, no installation for owner")
+	ErrPrivateKey     = errors.New("error, unable to parse private key")
+)
+
+type Installation struct {
+	ID                  int64  `json:"id"`
+	Account             User   `json:"account"`
+	RepositorySelection string `json:"repository_selection"`
+	AppID               int64  `json:"app_id"`
+	TargetType          string `json:"target_type"`
+}
+
+type InstallationRepositories struct {
+	TotalCount   int          `json:"total_count"`
+	Repositories []Repository `json:"repositories"`
+}
+
+type InstallationToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// GithubApp mints JWTs for the app and keeps installation tokens fresh
+type GithubApp struct {
+	AppID      int64
+	privateKey *rsa.PrivateKey
+	client     *GithubClient
+	mu         sync.Mutex
+	tokens     map[int64]*InstallationToken
+	owners     map[string]int64
+}
+
+func NewGithubApp(appID int64, privateKey *rsa.PrivateKey, apiURL string) *GithubApp {
+	app := &GithubApp{
+		AppID:      appID,
+		privateKey: privateKey,
+		tokens:     make(map[int64]*InstallationToken),
+		owners:     make(map[string]int64),
+	}
+	app.client = NewGithubClient(ConfigGithub{APIURL: apiURL})
+	app.client.auth = &appJWTAuthorizer{app: app}
+	return app
+}
+
+func (c *ConfigGithub) readPrivateKey() (*rsa.PrivateKey, error) {
+	keyText := c.PrivateKey
+	if keyText == "" && c.PrivateKeyPath != "" {
+		bytes, err := os.ReadFile(c.PrivateKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		keyText = string(bytes)
+	}
+	if !strings.Contains(keyText, "-----BEGIN") {
+		sDec, err := base64.StdEncoding.DecodeString(keyText)
+		if err != nil {
+			return nil, ErrPrivateKey
+		}
+		keyText = string(sDec)
+	}
+	return parsePrivateKey([]byte(keyText))
+}
+
+func parsePrivateKey(keyPEM []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, ErrPrivateKey
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, ErrPrivateKey
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ErrPrivateKey
+	}
+	return rsaKey, nil
+}
+
+// jwt returns a RS256 signed token used for the /app endpoints
+func (a *GithubApp) jwt() (string, error) {
+	now := time.Now()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iat": now.Add(-appJWTBackdate).Unix(),
+		"exp": now.Add(appJWTLifetime).Unix(),
+		"iss": strconv.FormatInt(a.AppID, 10),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.privateKey, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func (a *GithubApp) listInstallations() ([]Installation, error) {
+	var r []Installation
+	next := a.client.url("/app/installations")
+	for next != "" {
+		var page []Installation
+		nextURL, err := a.client.get(next, &page)
+		if err != nil {
+			return nil, err
+		}
+		next = nextURL
+		r = append(r, page...)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, installation := range r {
+		a.owners[strings.ToLower(installation.Account.Login)] = installation.ID
+	}
+	return r, nil
+}
+
+func (a *GithubApp) installationToken(id int64) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	token, found := a.tokens[id]
+	if found && time.Until(token.ExpiresAt) > installationTokenRefresh {
+		return token.Token, nil
+	}
+	token = new(InstallationToken)
+	_, err := a.client.do("POST", a.client.url("/app/installations/%v/access_tokens", id), nil, token)
+	if err != nil {
+		return "", err
+	}
+	debugLogger.Debug("installation token refreshed", "installation", id, "expires", token.ExpiresAt)
+	a.tokens[id] = token
+	return token.Token, nil
+}
+
+func (a *GithubApp) installationForOwner(owner string) (int64, error) {
+	a.mu.Lock()
+	id, found := a.owners[strings.ToLower(owner)]
+	a.mu.Unlock()
+	if found {
+		return id, nil
+	}
+	_, err := a.listInstallations()
+	if err != nil {
+		return 0, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	id, found = a.owners[strings.ToLower(owner)]
+	if !found {
+		return 0, fmt.Errorf("%w: %v", ErrNoInstallation, owner)
+	}
+	return id, nil
+}
+
+// ownerFromPath finds the account in paths like /repos/{owner}/{repo} or /orgs/{org}
+func ownerFromPath(path string) string {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(parts) < 2 {
+		return ""
+	}
+	switch parts[0] {
+	case "repos", "orgs", "users":
+		return parts[1]
+	}
+	return ""
+}
+
+type appJWTAuthorizer struct {
+	app *GithubApp
+}
+
+func (a *appJWTAuthorizer) authorization(url string) (string, error) {
+	return a.app.jwt()
+}
+
+type installationAuthorizer struct {
+	app            *GithubApp
+	installationID int64
+}
+
+func (a *installationAuthorizer) authorization(url string) (string, error) {
+	return a.app.installationToken(a.installationID)
+}
+
+// appAuthorizer picks the installation token from the owner the request is for
+type appAuthorizer struct {
+	app    *GithubApp
+	apiURL string
+}
+
+func (a *appAuthorizer) authorization(url string) (string, error) {
+	path := strings.TrimPrefix(url, a.apiURL)
+	if strings.HasPrefix(path, "/app/") || path == "/app" {
+		return a.app.jwt()
+	}
+	owner := ownerFromPath(strings.SplitN(path, "?", 2)[0])
+	if owner == "" {
+		return "", fmt.Errorf("%w: %v", ErrNoInstallation, path)
+	}
+	id, err := a.app.installationForOwner(owner)
+	if err != nil {
+		return "", err
+	}
+	return a.app.installationToken(id)
+}
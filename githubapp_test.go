@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_AppJWT(t *testing.T) {
+	setupTestlogging()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	config := ConfigGithub{AppID: 1234, PrivateKey: base64.StdEncoding.EncodeToString(keyPEM)}
+	parsed, err := config.readPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := NewGithubApp(config.AppID, parsed, defaultAPIURL)
+	token, err := app.jwt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("%v is not a jwt", token)
+	}
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hash[:], signature); err != nil {
+		t.Errorf("signature does not verify: %v", err)
+	}
+	claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	if !strings.Contains(string(claims), `"iss":"1234"`) {
+		t.Errorf("%s should contain issuer", claims)
+	}
+}
+
+func Test_OwnerFromPath(t *testing.T) {
+	tests := map[string]string{
+		"/repos/SimonStiil/kube-auth-proxy/hooks": "SimonStiil",
+		"/orgs/example/hooks":                     "example",
+		"/user/repos":                             "",
+		"/repos":                                  "",
+	}
+	for path, wanted := range tests {
+		if owner := ownerFromPath(path); owner != wanted {
+			t.Errorf("%v: %v should be %v", path, owner, wanted)
+		}
+	}
+}
+
+func Test_ListInstallationRepositories(t *testing.T) {
+	setupTestlogging()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	tokenRequests := 0
+	server := newTestGithubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		switch r.URL.Path {
+		case "/app/installations":
+			w.Write([]byte(`[{"id":42,"account":{"login":"SimonStiil"}}]`))
+		case "/app/installations/42/access_tokens":
+			tokenRequests += 1
+			fmt.Fprintf(w, `{"token":"installation-token","expires_at":"%v"}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+		case "/installation/repositories", "/repos/SimonStiil/one/pulls":
+			if auth != "Bearer installation-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"total_count":1,"repositories":[{"id":1,"full_name":"SimonStiil/one"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	c := &Crawler{Config: ConfigGithub{APIURL: server.URL, AppID: 1234, PrivateKey: string(keyPEM)}}
+	repos, err := c.ListRepositories()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repos) != 1 || repos[0].FullName != "SimonStiil/one" {
+		t.Errorf("unexpected repositories %v", repos)
+	}
+	_, err = c.client().get(c.client().url("/repos/SimonStiil/one/pulls"), nil)
+	if err != nil {
+		t.Errorf("owner lookup failed: %v", err)
+	}
+	if tokenRequests != 1 {
+		t.Errorf("installation token should be cached, requested %v times", tokenRequests)
+	}
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var ErrGraphQL = errors.New("error, graphql")
+
+// pullRequestsQuery fetches a page of pull requests with their labels, reviews, review requests and latest
+// timeline events, most recently updated first
+const pullRequestsQuery = `query($owner: String!, $name: String!, $first: Int!, $after: String) {
+  repository(owner: $owner, name: $name) {
+    pullRequests(first: $first, after: $after, orderBy: {field: UPDATED_AT, direction: DESC}) {
+      pageInfo { hasNextPage endCursor }
+      nodes {
+        id databaseId number state title body locked isDraft merged mergeable
+        createdAt updatedAt closedAt mergedAt
+        authorAssociation additions deletions changedFiles maintainerCanModify
+        mergeCommit { oid }
+        author { ...actor }
+        mergedBy { ...actor }
+        headRefName headRefOid headRepository { nameWithOwner }
+        headRepositoryOwner { __typename login avatarUrl ... on User { databaseId } ... on Organization { databaseId } }
+        baseRefName baseRefOid
+        commits { totalCount }
+        comments { totalCount }
+        milestone { id number title description state url createdAt updatedAt closedAt dueOn }
+        assignees(first: 20) { nodes { __typename login avatarUrl databaseId } }
+        reviewRequests(first: 20) {
+          nodes {
+            requestedReviewer {
+              __typename
+              ... on User { login avatarUrl databaseId }
+              ... on Bot { login avatarUrl databaseId }
+              ... on Team { id databaseId name slug description privacy url }
+            }
+          }
+        }
+        labels(first: 50) { nodes { id name color description isDefault } }
+        reviews(first: 50) {
+          pageInfo { hasNextPage }
+          nodes {
+            id databaseId state body url submittedAt authorAssociation
+            commit { oid }
+            comments { totalCount }
+            author { ...actor }
+          }
+        }
+        timelineItems(last: 25, itemTypes: [ASSIGNED_EVENT, CLOSED_EVENT, CONVERT_TO_DRAFT_EVENT, LABELED_EVENT, MERGED_EVENT, READY_FOR_REVIEW_EVENT, REOPENED_EVENT, REVIEW_REQUESTED_EVENT]) {
+          nodes {
+            __typename
+            ... on AssignedEvent { createdAt actor { ...actor } }
+            ... on ClosedEvent { createdAt actor { ...actor } }
+            ... on ConvertToDraftEvent { createdAt actor { ...actor } }
+            ... on LabeledEvent { createdAt actor { ...actor } }
+            ... on MergedEvent { createdAt actor { ...actor } }
+            ... on ReadyForReviewEvent { createdAt actor { ...actor } }
+            ... on ReopenedEvent { createdAt actor { ...actor } }
+            ... on ReviewRequestedEvent { createdAt actor { ...actor } }
+          }
+        }
+      }
+    }
+  }
+}
+
+fragment actor on Actor { __typename login avatarUrl ... on User { databaseId } ... on Bot { databaseId } }`
+
+// timelineEvents are the names the REST timeline api uses for the graphql timeline item types
+var timelineEvents = map[string]string{
+	"AssignedEvent":        "assigned",
+	"ClosedEvent":          "closed",
+	"ConvertToDraftEvent":  "convert_to_draft",
+	"LabeledEvent":         "labeled",
+	"MergedEvent":          "merged",
+	"ReadyForReviewEvent":  "ready_for_review",
+	"ReopenedEvent":        "reopened",
+	"ReviewRequestedEvent": "review_requested",
+}
+
+type graphqlRequest struct {
+	Query     string         `json:"query"`
+	Variables map[string]any `json:"variables"`
+}
+
+type graphqlError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// graphql posts a query and decodes data into v, errors in the response body are returned as ErrGraphQL
+func (g *GithubClient) graphql(query string, variables map[string]any, v any) error {
+	response := struct {
+		Data   any            `json:"data"`
+		Errors []graphqlError `json:"errors"`
+	}{Data: v}
+	_, err := g.do("POST", g.GraphQLURL, graphqlRequest{Query: query, Variables: variables}, &response)
+	if err != nil {
+		return err
+	}
+	if len(response.Errors) > 0 {
+		if response.Errors[0].Type == "RATE_LIMITED" {
+			// answered with 200, do only blocks on error statuses
+			ratelimit := g.limits.resource("graphql")
+			if ratelimit == nil {
+				ratelimit = &RateLimit{Resource: "graphql"}
+			}
+			until := g.limits.block(http.Header{}, ratelimit)
+			logger.Warn("rate limited by github", "method", "POST", "url", g.GraphQLURL, "resource", "graphql", "until", until)
+			return ErrRateLimited
+		}
+		return fmt.Errorf("%w: %v", ErrGraphQL, response.Errors[0].Message)
+	}
+	return nil
+}
+
+// forOwner is the client to use for requests that have no owner in the path, like graphql
+func (g *GithubClient) forOwner(owner string) (*GithubClient, error) {
+	if g.app == nil {
+		return g, nil
+	}
+	id, err := g.app.installationForOwner(owner)
+	if err != nil {
+		return nil, err
+	}
+	return g.withInstallation(id), nil
+}
+
+type graphqlActor struct {
+	Typename   string `json:"__typename"`
+	Login      string `json:"login"`
+	AvatarURL  string `json:"avatarUrl"`
+	DatabaseID int64  `json:"databaseId"`
+}
+
+func (a *graphqlActor) toUser() User {
+	if a == nil {
+		// deleted accounts show up as ghost in the REST API
+		return User{Login: "ghost"}
+	}
+	return User{Login: a.Login, ID: a.DatabaseID, AvatarURL: a.AvatarURL, Type: a.Typename}
+}
+
+type graphqlLabel struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Color       string `json:"color"`
+	Description string `json:"description"`
+	IsDefault   bool   `json:"isDefault"`
+}
+
+type graphqlReview struct {
+	ID                string        `json:"id"`
+	DatabaseID        int64         `json:"databaseId"`
+	State             string        `json:"state"`
+	Body              string        `json:"body"`
+	URL               string        `json:"url"`
+	SubmittedAt       *time.Time    `json:"submittedAt"`
+	AuthorAssociation string        `json:"authorAssociation"`
+	Author            *graphqlActor `json:"author"`
+	Commit            *struct {
+		Oid string `json:"oid"`
+	} `json:"commit"`
+	Comments struct {
+		TotalCount int64 `json:"totalCount"`
+	} `json:"comments"`
+}
+
+type graphqlMilestone struct {
+	ID          string     `json:"id"`
+	Number      int64      `json:"number"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	State       string     `json:"state"`
+	URL         string     `json:"url"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+	ClosedAt    *time.Time `json:"closedAt"`
+	DueOn       *time.Time `json:"dueOn"`
+}
+
+func (m *graphqlMilestone) toMilestone(repository Repository, apiURL string) *Milestone {
+	milestone := &Milestone{
+		URL:         fmt.Sprintf("%v/repos/%v/milestones/%v", apiURL, repository.FullName, m.Number),
+		HTMLURL:     m.URL,
+		NodeID:      m.ID,
+		Number:      m.Number,
+		State:       strings.ToLower(m.State),
+		Title:       m.Title,
+		Description: m.Description,
+		CreatedAt:   m.CreatedAt,
+		UpdatedAt:   m.UpdatedAt,
+	}
+	if m.ClosedAt != nil {
+		milestone.ClosedAt = *m.ClosedAt
+	}
+	if m.DueOn != nil {
+		milestone.DueOn = *m.DueOn
+	}
+	return milestone
+}
+
+// graphqlReviewer is a user, bot or team a review was requested from
+type graphqlReviewer struct {
+	Typename    string `json:"__typename"`
+	ID          string `json:"id"`
+	DatabaseID  int64  `json:"databaseId"`
+	Login       string `json:"login"`
+	AvatarURL   string `json:"avatarUrl"`
+	Name        string `json:"name"`
+	Slug        string `json:"slug"`
+	Description string `json:"description"`
+	Privacy     string `json:"privacy"`
+	URL         string `json:"url"`
+}
+
+type graphqlTimelineItem struct {
+	Typename  string        `json:"__typename"`
+	CreatedAt time.Time     `json:"createdAt"`
+	Actor     *graphqlActor `json:"actor"`
+}
+
+type graphqlPullRequest struct {
+	ID                  string        `json:"id"`
+	DatabaseID          int64         `json:"databaseId"`
+	Number              int64         `json:"number"`
+	State               string        `json:"state"`
+	Title               string        `json:"title"`
+	Body                string        `json:"body"`
+	Locked              bool          `json:"locked"`
+	IsDraft             bool          `json:"isDraft"`
+	Merged              bool          `json:"merged"`
+	Mergeable           string        `json:"mergeable"`
+	CreatedAt           time.Time     `json:"createdAt"`
+	UpdatedAt           time.Time     `json:"updatedAt"`
+	ClosedAt            *time.Time    `json:"closedAt"`
+	MergedAt            *time.Time    `json:"mergedAt"`
+	AuthorAssociation   string        `json:"authorAssociation"`
+	Additions           int64         `json:"additions"`
+	Deletions           int64         `json:"deletions"`
+	ChangedFiles        int64         `json:"changedFiles"`
+	MaintainerCanModify bool          `json:"maintainerCanModify"`
+	Author              *graphqlActor `json:"author"`
+	MergedBy            *graphqlActor `json:"mergedBy"`
+	MergeCommit         *struct {
+		Oid string `json:"oid"`
+	} `json:"mergeCommit"`
+	HeadRefName    string `json:"headRefName"`
+	HeadRefOid     string `json:"headRefOid"`
+	HeadRepository *struct {
+		NameWithOwner string `json:"nameWithOwner"`
+	} `json:"headRepository"`
+	HeadRepositoryOwner *graphqlActor     `json:"headRepositoryOwner"`
+	Milestone           *graphqlMilestone `json:"milestone"`
+	Assignees           struct {
+		Nodes []graphqlActor `json:"nodes"`
+	} `json:"assignees"`
+	ReviewRequests struct {
+		Nodes []struct {
+			RequestedReviewer *graphqlReviewer `json:"requestedReviewer"`
+		} `json:"nodes"`
+	} `json:"reviewRequests"`
+	TimelineItems struct {
+		Nodes []graphqlTimelineItem `json:"nodes"`
+	} `json:"timelineItems"`
+	BaseRefName string `json:"baseRefName"`
+	BaseRefOid  string `json:"baseRefOid"`
+	Commits     struct {
+		TotalCount int64 `json:"totalCount"`
+	} `json:"commits"`
+	Comments struct {
+		TotalCount int64 `json:"totalCount"`
+	} `json:"comments"`
+	Labels struct {
+		Nodes []graphqlLabel `json:"nodes"`
+	} `json:"labels"`
+	Reviews struct {
+		PageInfo struct {
+			HasNextPage bool `json:"hasNextPage"`
+		} `json:"pageInfo"`
+		Nodes []graphqlReview `json:"nodes"`
+	} `json:"reviews"`
+}
+
+// toPullRequest maps to the REST shape. Fields graphql has no equivalent for, like rebaseable, mergeable_state
+// and the repository details of head, stay empty, timeline is only filled by this backend
+func (p *graphqlPullRequest) toPullRequest(repository Repository, apiURL string) PullRequest {
+	pull := PullRequest{
+		URL:                 fmt.Sprintf("%v/repos/%v/pulls/%v", apiURL, repository.FullName, p.Number),
+		ID:                  p.DatabaseID,
+		NodeID:              p.ID,
+		Number:              p.Number,
+		State:               "open",
+		Locked:              p.Locked,
+		Title:               p.Title,
+		User:                p.Author.toUser(),
+		Body:                p.Body,
+		CreatedAt:           p.CreatedAt,
+		UpdatedAt:           p.UpdatedAt,
+		ClosedAt:            p.ClosedAt,
+		MergedAt:            p.MergedAt,
+		Draft:               p.IsDraft,
+		AuthorAssociation:   p.AuthorAssociation,
+		Merged:              p.Merged,
+		Comments:            p.Comments.TotalCount,
+		MaintainerCanModify: p.MaintainerCanModify,
+		Commits:             p.Commits.TotalCount,
+		Additions:           p.Additions,
+		Deletions:           p.Deletions,
+		ChangedFiles:        p.ChangedFiles,
+		Head:                Reference{Ref: p.HeadRefName, Sha: p.HeadRefOid},
+		Base:                Reference{Ref: p.BaseRefName, Sha: p.BaseRefOid, User: repository.Owner, Repo: repository},
+	}
+	if p.State != "OPEN" {
+		pull.State = "closed"
+	}
+	if p.MergeCommit != nil {
+		pull.MergeCommitSha = &p.MergeCommit.Oid
+	}
+	if p.MergedBy != nil {
+		mergedBy := p.MergedBy.toUser()
+		pull.MergedBy = &mergedBy
+	}
+	switch p.Mergeable {
+	case "MERGEABLE":
+		mergeable := true
+		pull.Mergeable = &mergeable
+	case "CONFLICTING":
+		mergeable := false
+		pull.Mergeable = &mergeable
+	}
+	if p.HeadRepository != nil {
+		owner, _, _ := strings.Cut(p.HeadRepository.NameWithOwner, "/")
+		pull.Head.Label = owner + ":" + p.HeadRefName
+		pull.Head.Repo = Repository{FullName: p.HeadRepository.NameWithOwner}
+	}
+	if p.HeadRepositoryOwner != nil {
+		pull.Head.User = p.HeadRepositoryOwner.toUser()
+		pull.Head.Repo.Owner = pull.Head.User
+	}
+	pull.Base.Label = repository.Owner.Login + ":" + p.BaseRefName
+	for _, label := range p.Labels.Nodes {
+		pull.Labels = append(pull.Labels, Label{NodeID: label.ID, Name: label.Name, Color: label.Color, Description: label.Description, Default: label.IsDefault})
+	}
+	if p.Milestone != nil {
+		pull.Milestone = p.Milestone.toMilestone(repository, apiURL)
+	}
+	if len(p.Assignees.Nodes) > 0 {
+		assignees := make([]User, 0, len(p.Assignees.Nodes))
+		for _, assignee := range p.Assignees.Nodes {
+			assignees = append(assignees, assignee.toUser())
+		}
+		pull.Assignee = &assignees[0]
+		pull.Assignees = &assignees
+	}
+	for _, request := range p.ReviewRequests.Nodes {
+		reviewer := request.RequestedReviewer
+		switch {
+		case reviewer == nil:
+		case reviewer.Typename == "Team":
+			if pull.RequestedTeam == nil {
+				pull.RequestedTeam = &Team{Name: reviewer.Name, ID: reviewer.DatabaseID, NodeID: reviewer.ID, Slug: reviewer.Slug, Description: reviewer.Description, Privacy: strings.ToLower(reviewer.Privacy), URL: reviewer.URL}
+			}
+		default:
+			pull.RequestedReviewers = append(pull.RequestedReviewers, User{Login: reviewer.Login, ID: reviewer.DatabaseID, AvatarURL: reviewer.AvatarURL, Type: reviewer.Typename})
+		}
+	}
+	for _, review := range p.Reviews.Nodes {
+		pull.ReviewComments += review.Comments.TotalCount
+	}
+	for _, item := range p.TimelineItems.Nodes {
+		pull.Timeline = append(pull.Timeline, TimelineEvent{Event: timelineEvents[item.Typename], Actor: item.Actor.toUser(), CreatedAt: item.CreatedAt})
+	}
+	return pull
+}
+
+func (r *graphqlReview) toPullRequestReview(pull PullRequest) PullRequestReview {
+	review := PullRequestReview{
+		ID:                r.DatabaseID,
+		NodeID:            r.ID,
+		User:              r.Author.toUser(),
+		Body:              r.Body,
+		State:             r.State,
+		HTMLURL:           r.URL,
+		PullRequestURL:    pull.URL,
+		SubmittedAt:       r.SubmittedAt,
+		AuthorAssociation: r.AuthorAssociation,
+	}
+	if r.Commit != nil {
+		review.CommitID = r.Commit.Oid
+	}
+	return review
+}
+
+// crawlPullRequestsGraphQL is crawlPullRequests using one graphql query per page for pull requests and their reviews
+func (c *Crawler) crawlPullRequestsGraphQL(repository Repository, lastSeen time.Time) (time.Time, error) {
+	pageSize := c.Config.PRPageSize
+	if pageSize <= 0 || pageSize > 100 {
+		pageSize = 50
+	}
+	windowStart := time.Now().Add(-crawlWindow)
+	if lastSeen.After(windowStart) {
+		windowStart = lastSeen
+	}
+	client, err := c.client().forOwner(repository.Owner.Login)
+	if err != nil {
+		return time.Time{}, err
+	}
+	owner, name, _ := strings.Cut(repository.FullName, "/")
+	variables := map[string]any{"owner": owner, "name": name, "first": pageSize, "after": nil}
+	newest := time.Time{}
+	failed := time.Time{}
+	idx := 0
+	for {
+		var data struct {
+			Repository struct {
+				PullRequests struct {
+					PageInfo struct {
+						HasNextPage bool   `json:"hasNextPage"`
+						EndCursor   string `json:"endCursor"`
+					} `json:"pageInfo"`
+					Nodes []graphqlPullRequest `json:"nodes"`
+				} `json:"pullRequests"`
+			} `json:"repository"`
+		}
+		if err := client.graphql(pullRequestsQuery, variables, &data); err != nil {
+			logger.Error("error querying pull requests", "repo", repository.FullName, "error", err)
+			return newest, err
+		}
+		for _, node := range data.Repository.PullRequests.Nodes {
+			if node.UpdatedAt.Before(windowStart) {
+				debugLogger.Debug("Reached PRs outside crawl window", "repo", repository.FullName, "id", idx, "number", node.Number, "updated", node.UpdatedAt)
+				return resumeAt(newest, failed), nil
+			}
+			if node.UpdatedAt.After(newest) {
+				newest = node.UpdatedAt
+			}
+			pull := node.toPullRequest(repository, client.APIURL)
+			if c.Config.crawlEvent("pull_request") {
+				if err := c.pushPullRequest(repository, idx, pull); err != nil {
+					failed = node.UpdatedAt
+				}
+			}
+			if c.Config.crawlEvent("pull_request_review") {
+				if node.Reviews.PageInfo.HasNextPage {
+					if err := c.crawlReviews(repository, pull); errors.Is(err, ErrRateLimited) {
+						return resumeAt(newest, failed), err
+					} else if err != nil {
+						failed = node.UpdatedAt
+					}
+				} else {
+					for _, review := range node.Reviews.Nodes {
+						if err := c.pushReview(repository, pull, review.toPullRequestReview(pull)); err != nil {
+							failed = node.UpdatedAt
+						}
+					}
+				}
+			}
+			idx += 1
+		}
+		pageInfo := data.Repository.PullRequests.PageInfo
+		if !pageInfo.HasNextPage {
+			return resumeAt(newest, failed), nil
+		}
+		variables["after"] = pageInfo.EndCursor
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_GraphQLPullRequestMapping(t *testing.T) {
+	node := graphqlPullRequest{DatabaseID: 42, Number: 7, State: "MERGED", Merged: true, Mergeable: "CONFLICTING", HeadRefName: "feature", BaseRefName: "main"}
+	node.Labels.Nodes = append(node.Labels.Nodes, graphqlLabel{Name: "bug"})
+	repository := Repository{FullName: "owner/repo", Owner: User{Login: "owner"}}
+	pull := node.toPullRequest(repository, "https://api.github.com")
+	rest := PullRequest{ID: 42, Number: 7, State: "closed", Base: Reference{Repo: repository}}
+	if pull.generateUUID() != rest.generateUUID() {
+		t.Errorf("graphql and rest pull requests should get the same document id")
+	}
+	if pull.User.Login != "ghost" || pull.Mergeable == nil || *pull.Mergeable || len(pull.Labels) != 1 {
+		t.Errorf("unexpected mapping %+v", pull)
+	}
+	if pull.URL != "https://api.github.com/repos/owner/repo/pulls/7" {
+		t.Errorf("unexpected url %v", pull.URL)
+	}
+}
+
+func Test_GraphQLPullRequestDetails(t *testing.T) {
+	var node graphqlPullRequest
+	err := json.Unmarshal([]byte(`{"number":7,"state":"OPEN",
+		"headRepositoryOwner":{"__typename":"User","login":"fork-owner","databaseId":5},
+		"milestone":{"number":3,"title":"v1","state":"OPEN","dueOn":"2025-02-01T00:00:00Z"},
+		"assignees":{"nodes":[{"__typename":"User","login":"first"},{"__typename":"User","login":"second"}]},
+		"reviewRequests":{"nodes":[{"requestedReviewer":{"__typename":"User","login":"reviewer"}},{"requestedReviewer":{"__typename":"Team","name":"Core","slug":"core","privacy":"VISIBLE"}}]},
+		"reviews":{"nodes":[{"comments":{"totalCount":2}},{"comments":{"totalCount":1}}]},
+		"timelineItems":{"nodes":[{"__typename":"ReviewRequestedEvent","createdAt":"2025-01-02T03:04:05Z","actor":{"login":"fork-owner"}}]}}`), &node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repository := Repository{FullName: "owner/repo", Owner: User{Login: "owner"}}
+	pull := node.toPullRequest(repository, "https://api.github.com")
+	event, err := pull.toPullRequestEvent()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Sender.Login != "fork-owner" {
+		t.Errorf("sender should be the head repository owner, got %v", event.Sender.Login)
+	}
+	if pull.Assignee == nil || pull.Assignee.Login != "first" || pull.Assignees == nil || len(*pull.Assignees) != 2 {
+		t.Errorf("unexpected assignees %v %v", pull.Assignee, pull.Assignees)
+	}
+	if len(pull.RequestedReviewers) != 1 || pull.RequestedReviewers[0].Login != "reviewer" || pull.RequestedTeam == nil || pull.RequestedTeam.Slug != "core" {
+		t.Errorf("unexpected review requests %v %v", pull.RequestedReviewers, pull.RequestedTeam)
+	}
+	if pull.Milestone == nil || pull.Milestone.Title != "v1" || pull.Milestone.State != "open" || pull.Milestone.DueOn.IsZero() {
+		t.Errorf("unexpected milestone %+v", pull.Milestone)
+	}
+	if pull.ReviewComments != 3 {
+		t.Errorf("%v review comments should be 3", pull.ReviewComments)
+	}
+	if len(pull.Timeline) != 1 || pull.Timeline[0].Event != "review_requested" || pull.Timeline[0].Actor.Login != "fork-owner" {
+		t.Errorf("unexpected timeline %+v", pull.Timeline)
+	}
+}
+
+func Test_GraphQLRateLimited(t *testing.T) {
+	setupTestlogging()
+	reset := time.Now().Add(time.Hour).Truncate(time.Second)
+	server := newTestGithubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Ratelimit-Resource", "graphql")
+		w.Header().Set("X-Ratelimit-Remaining", "0")
+		w.Header().Set("X-Ratelimit-Reset", fmt.Sprint(reset.Unix()))
+		w.Write([]byte(`{"errors":[{"type":"RATE_LIMITED","message":"API rate limit exceeded"}]}`))
+	})
+	c := &Crawler{Config: ConfigGithub{GraphQLURL: server.URL + "/graphql", Backend: "graphql"}}
+	var data any
+	if err := c.client().graphql(pullRequestsQuery, nil, &data); err != ErrRateLimited {
+		t.Errorf("%v should be %v", err, ErrRateLimited)
+	}
+	if until := c.client().limits.blockedUntil(); !until.Equal(reset) {
+		t.Errorf("should be blocked until the graphql reset %v, got %v", reset, until)
+	}
+	if budget, resource := c.budget(); budget != 0 || resource != "graphql" {
+		t.Errorf("budget should be the spent graphql resource, got %v %v", budget, resource)
+	}
+}
+
+func Test_CrawlPullRequestsGraphQL(t *testing.T) {
+	setupTestlogging()
+	recent := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	old := time.Now().Add(-96 * time.Hour).Format(time.RFC3339)
+	queries := 0
+	server := newTestGithubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		var request graphqlRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Fatal(err)
+		}
+		queries += 1
+		if request.Variables["after"] == nil {
+			fmt.Fprintf(w, `{"data":{"repository":{"pullRequests":{"pageInfo":{"hasNextPage":true,"endCursor":"c1"},"nodes":[
+				{"databaseId":2,"number":2,"state":"OPEN","updatedAt":"%v","createdAt":"%v","author":{"login":"dev"},
+				 "reviews":{"pageInfo":{"hasNextPage":false},"nodes":[{"databaseId":9,"state":"APPROVED","submittedAt":"%v","author":{"login":"reviewer"}}]}}]}}}}`, recent, recent, recent)
+			return
+		}
+		fmt.Fprintf(w, `{"data":{"repository":{"pullRequests":{"pageInfo":{"hasNextPage":true,"endCursor":"c2"},"nodes":[
+			{"databaseId":1,"number":1,"state":"CLOSED","updatedAt":"%v","createdAt":"%v"}]}}}}`, old, old)
+	})
+	search := newTestSearch(t, func(documentID string) int { return http.StatusCreated })
+	c := &Crawler{Config: ConfigGithub{GraphQLURL: server.URL + "/graphql", CrawlEvents: []string{"pull_request", "pull_request_review"}}, Sink: search}
+	created := documentCount(t, "created")
+	newest, err := c.crawlPullRequestsGraphQL(Repository{FullName: "owner/repo", Owner: User{Login: "owner"}}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := search.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if queries != 2 {
+		t.Errorf("crawl should stop at the window, got %v queries", queries)
+	}
+	if newest.Format(time.RFC3339) != recent {
+		t.Errorf("%v should be %v", newest, recent)
+	}
+	if documentCount(t, "created")-created != 2 {
+		t.Errorf("expected pull request and review documents")
+	}
+}
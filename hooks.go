@@ -0,0 +1,374 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+type WebHook struct {
+	Type          *string       `json:"type,omitempty"`
+	ID            *int64        `json:"id,omitempty"`
+	Name          string        `json:"name,omitempty"`
+	Active        bool          `json:"active"`
+	Events        *[]string     `json:"events,omitempty"`
+	Config        WebHookConfig `json:"config,omitempty"`
+	UpdatedAt     *time.Time    `json:"updated_at,omitempty"`
+	CreatedAt     *time.Time    `json:"created_at,omitempty"`
+	URL           *string       `json:"url,omitempty"`
+	TestURL       *string       `json:"test_url,omitempty"`
+	PingURL       *string       `json:"ping_url,omitempty"`
+	DeliveriesURL *string       `json:"deliveries_url,omitempty"`
+	LastResponse  *LastResponse `json:"last_response,omitempty"`
+}
+
+func (webhook *WebHook) String() string {
+	if webhook.LastResponse == nil {
+		return fmt.Sprintf("ID: %v, URL: %v", *webhook.ID, webhook.Config.URL)
+	}
+	return fmt.Sprintf("ID: %v, URL: %v, LastResponseCode: %v", *webhook.ID, webhook.Config.URL, webhook.LastResponse.Code)
+}
+
+type LastResponse struct {
+	Code    int    `json:"code"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+type WebHookConfig struct {
+	ContentType string  `json:"content_type"`
+	InsecureSSL *string `json:"insecure_ssl,omitempty"`
+	URL         string  `json:"url"`
+	// GitHub masks the secret as ******** when reading hooks, so only its presence can be compared
+	Secret string `json:"secret,omitempty"`
+}
+
+type ConfigWebhook struct {
+	Events      []string `mapstructure:"events"`
+	ContentType string   `mapstructure:"content_type"`
+	InsecureSSL bool     `mapstructure:"insecure_ssl"`
+	Active      *bool    `mapstructure:"active"`
+	Reconcile   bool     `mapstructure:"reconcile"`
+	Monitor     bool     `mapstructure:"monitor"`
+	// Redeliver failed deliveries newer than RedeliverLookback
+	Redeliver         bool          `mapstructure:"redeliver"`
+	RedeliverLookback time.Duration `mapstructure:"redeliver_lookback"`
+	// Organizations get a single org hook instead of one hook per repository
+	Organizations []string `mapstructure:"organizations"`
+}
+
+var defaultWebHookEvents = []string{"pull_request", "pull_request_review"}
+
+// WebHookUpdate is the body for PATCH, unlike WebHook it always sends active and events
+type WebHookUpdate struct {
+	Active bool          `json:"active"`
+	Events []string      `json:"events"`
+	Config WebHookConfig `json:"config"`
+}
+
+func (c *ConfigGithub) desiredWebHook() WebHook {
+	events := c.Webhook.Events
+	if len(events) == 0 {
+		events = defaultWebHookEvents
+	}
+	contentType := c.Webhook.ContentType
+	if contentType == "" {
+		contentType = "json"
+	}
+	insecureSSL := "0"
+	if c.Webhook.InsecureSSL {
+		insecureSSL = "1"
+	}
+	active := c.Webhook.Active == nil || *c.Webhook.Active
+	secret := c.Secret
+	if secret == defaultSecret {
+		secret = ""
+	}
+	return WebHook{
+		Name:   "web",
+		Active: active,
+		Events: &events,
+		Config: WebHookConfig{URL: c.getWebHookURL(), ContentType: contentType, InsecureSSL: &insecureSSL, Secret: secret},
+	}
+}
+
+// diffWebHook lists the differences from an existing hook to the desired one
+func diffWebHook(existing WebHook, desired WebHook) []string {
+	var changes []string
+	if existing.Active != desired.Active {
+		changes = append(changes, fmt.Sprintf("active: %v -> %v", existing.Active, desired.Active))
+	}
+	existingEvents := []string{}
+	if existing.Events != nil {
+		existingEvents = append(existingEvents, *existing.Events...)
+	}
+	desiredEvents := append([]string{}, *desired.Events...)
+	sort.Strings(existingEvents)
+	sort.Strings(desiredEvents)
+	if !slices.Equal(existingEvents, desiredEvents) {
+		changes = append(changes, fmt.Sprintf("events: %v -> %v", existingEvents, desiredEvents))
+	}
+	if existing.Config.ContentType != desired.Config.ContentType {
+		changes = append(changes, fmt.Sprintf("content_type: %v -> %v", existing.Config.ContentType, desired.Config.ContentType))
+	}
+	existingInsecureSSL := "0"
+	if existing.Config.InsecureSSL != nil {
+		existingInsecureSSL = *existing.Config.InsecureSSL
+	}
+	if existingInsecureSSL != *desired.Config.InsecureSSL {
+		changes = append(changes, fmt.Sprintf("insecure_ssl: %v -> %v", existingInsecureSSL, *desired.Config.InsecureSSL))
+	}
+	if (existing.Config.Secret == "") != (desired.Config.Secret == "") {
+		changes = append(changes, fmt.Sprintf("secret: set %v -> %v", existing.Config.Secret != "", desired.Config.Secret != ""))
+	}
+	return changes
+}
+
+func (c *Crawler) updateWebHooks(repoFullName string) error {
+	if c.Config.getWebHookURL() == "" {
+		return nil
+	}
+	owner, _, _ := strings.Cut(repoFullName, "/")
+	if c.orgHooked(owner) {
+		debugLogger.Debug("webhook covered by organization hook", "repository", repoFullName)
+		return c.removeRedundantWebHooks(repoFullName)
+	}
+	if c.Config.Webhook.orgConfigured(owner) {
+		logger.Warn("organization hook missing, managing repository hook instead", "repository", repoFullName)
+		// checked again for duplicates once the organization hook is back
+		c.mu.Lock()
+		delete(c.orgCovered, repoFullName)
+		c.mu.Unlock()
+	}
+	_, err := c.manageWebHook(c.client().url("/repos/%v/hooks", repoFullName), repoFullName)
+	return err
+}
+
+// orgHooked tells if the owner is an organization whose hook was found or created by the last updateOrgWebHooks
+func (c *Crawler) orgHooked(owner string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.orgHooks[strings.ToLower(owner)]
+}
+
+// removeRedundantWebHooks deletes, with reconcile enabled, repository hooks with the url of the organization
+// hook as they would deliver every event twice. Without reconcile they are only logged. Checked once per repository
+func (c *Crawler) removeRedundantWebHooks(repoFullName string) error {
+	c.mu.Lock()
+	checked := c.orgCovered[repoFullName]
+	c.mu.Unlock()
+	if checked {
+		return nil
+	}
+	hooksURL := c.client().url("/repos/%v/hooks", repoFullName)
+	webhooks, err := c.listWebHooks(hooksURL)
+	if errors.Is(err, ErrStatusForbidden) || errors.Is(err, ErrStatusNotFound) {
+		// without admin access there are no repository hooks to see, the org hook covers the repository
+		debugLogger.Debug("unable to list webhooks", "repository", repoFullName, "error", err)
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	webhookURL := c.Config.getWebHookURL()
+	for _, webhook := range webhooks {
+		if webhook.Config.URL != webhookURL {
+			continue
+		}
+		if !c.Config.Webhook.Reconcile {
+			logger.Warn("webhook duplicates organization hook, events are delivered twice", "repository", repoFullName, "id", *webhook.ID)
+			continue
+		}
+		_, err := c.client().do("DELETE", fmt.Sprintf("%v/%v", hooksURL, *webhook.ID), nil, nil)
+		if err != nil {
+			return err
+		}
+		logger.Info("webhook duplicating organization hook deleted", "repository", repoFullName, "id", *webhook.ID)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.orgCovered == nil {
+		c.orgCovered = make(map[string]bool)
+	}
+	c.orgCovered[repoFullName] = true
+	return nil
+}
+
+// updateOrgWebHooks manages one hook per configured organization, covering all of its repositories.
+// Organizations where the hook could not be found or created keep per repository hooks
+func (c *Crawler) updateOrgWebHooks() {
+	if c.Config.getWebHookURL() == "" {
+		return
+	}
+	hooked := make(map[string]bool)
+	for _, org := range c.Config.Webhook.Organizations {
+		exists, err := c.manageWebHook(c.client().url("/orgs/%v/hooks", org), org)
+		if err != nil {
+			logger.Error("error updateOrgWebHooks", "organization", org, "error", err)
+		}
+		if exists {
+			hooked[strings.ToLower(org)] = true
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.orgHooks = hooked
+}
+
+func (c *ConfigWebhook) orgConfigured(owner string) bool {
+	for _, org := range c.Organizations {
+		if strings.EqualFold(org, owner) {
+			return true
+		}
+	}
+	return false
+}
+
+// manageWebHook creates the hook if missing and, with reconcile enabled, updates an existing hook
+// with the same url to match the desired config. Tells if the hook exists, even when updating or
+// monitoring it failed
+func (c *Crawler) manageWebHook(hooksURL string, target string) (bool, error) {
+	desired := c.Config.desiredWebHook()
+	webhooks, err := c.listWebHooks(hooksURL)
+	if err != nil {
+		return false, err
+	}
+	for _, webhook := range webhooks {
+		if webhook.Config.URL != desired.Config.URL {
+			continue
+		}
+		hookURL := fmt.Sprintf("%v/%v", hooksURL, *webhook.ID)
+		if err := c.reconcileWebHook(hookURL, target, webhook, desired); err != nil {
+			return true, err
+		}
+		if c.Config.Webhook.Monitor {
+			return true, c.monitorWebHook(hookURL, target, webhook)
+		}
+		return true, nil
+	}
+	newWebhook, err := c.createWebHook(hooksURL, desired)
+	if err != nil {
+		return false, err
+	}
+	logger.Info("webhook created", "target", target, "id", newWebhook.ID)
+	return true, nil
+}
+
+// reconcileWebHook updates a hook that differs from the desired config when reconcile is enabled. While a
+// previous secret is configured every hook is given the new secret once, whether reconcile is enabled or not,
+// as GitHub masks secrets and a hook that does not deliver during the rotation would otherwise keep the old one
+func (c *Crawler) reconcileWebHook(hookURL string, target string, webhook WebHook, desired WebHook) error {
+	changes := diffWebHook(webhook, desired)
+	rotate := desired.Config.Secret != "" &&
+		((c.Config.PreviousSecret != "" && !c.hookRotated(hookURL)) || c.Rotation.usesPrevious(target))
+	if len(changes) == 0 && !rotate {
+		debugLogger.Debug("webhook already exists skipping", "target", target)
+		return nil
+	}
+	update := desired
+	if !c.Config.Webhook.Reconcile {
+		if len(changes) > 0 {
+			logger.Info("webhook differs from desired config", "target", target, "id", *webhook.ID, "changes", changes)
+		}
+		if !rotate {
+			return nil
+		}
+		// only the secret is changed
+		update = webhook
+		update.Config.Secret = desired.Config.Secret
+		changes = nil
+	}
+	if rotate {
+		changes = append(changes, "secret: rotated")
+	}
+	_, err := c.updateWebHook(hookURL, update)
+	if err != nil {
+		return err
+	}
+	if rotate {
+		c.markHookRotated(hookURL)
+	}
+	c.Rotation.markCurrent(target)
+	logger.Info("webhook reconciled", "target", target, "id", *webhook.ID, "changes", changes)
+	return nil
+}
+
+// rotationID identifies a secret rotation by the secrets involved without storing either of them
+func (c *ConfigGithub) rotationID() string {
+	mac := hmac.New(sha256.New, []byte(c.Secret))
+	mac.Write([]byte(c.PreviousSecret))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// hookRotated tells if a hook was given the new secret during the current rotation. It is kept in the
+// checkpoint so a restart does not update every hook again
+func (c *Crawler) hookRotated(hookURL string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.checkpoint == nil || c.checkpoint.Rotation != c.Config.rotationID() {
+		return false
+	}
+	return slices.Contains(c.checkpoint.RotatedHooks, hookURL)
+}
+
+func (c *Crawler) markHookRotated(hookURL string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.checkpoint == nil {
+		return
+	}
+	if rotation := c.Config.rotationID(); c.checkpoint.Rotation != rotation {
+		c.checkpoint.Rotation = rotation
+		c.checkpoint.RotatedHooks = nil
+	}
+	c.checkpoint.RotatedHooks = append(c.checkpoint.RotatedHooks, hookURL)
+	c.saveCheckpoint()
+}
+
+func (c *Crawler) listWebHooks(hooksURL string) ([]WebHook, error) {
+	var r []WebHook
+	next := hooksURL
+	if c.Config.WebhookPageSize > 0 {
+		next = fmt.Sprintf("%v?per_page=%v", hooksURL, c.Config.WebhookPageSize)
+	}
+	for next != "" {
+		page, nextURL, err := c.getWebHooksPage(next)
+		if err != nil {
+			return nil, err
+		}
+		next = nextURL
+		r = append(r, page...)
+	}
+	return r, nil
+}
+
+func (c *Crawler) getWebHooksPage(url string) ([]WebHook, string, error) {
+	var r []WebHook
+	nextURL, err := c.client().get(url, &r)
+	return r, nextURL, err
+}
+
+func (c *Crawler) createWebHook(url string, webhook WebHook) (*WebHook, error) {
+	r := new(WebHook)
+	_, err := c.client().do("POST", url, webhook, r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (c *Crawler) updateWebHook(url string, webhook WebHook) (*WebHook, error) {
+	update := WebHookUpdate{Active: webhook.Active, Events: *webhook.Events, Config: webhook.Config}
+	r := new(WebHook)
+	_, err := c.client().do("PATCH", url, update, r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
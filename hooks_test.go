@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func Test_DiffWebHook(t *testing.T) {
+	config := ConfigGithub{Endpoint: "/webhook", PublicAddress: "http://localhost"}
+	desired := config.desiredWebHook()
+	existing := config.desiredWebHook()
+	reordered := []string{"pull_request_review", "pull_request"}
+	existing.Events = &reordered
+	if changes := diffWebHook(existing, desired); len(changes) != 0 {
+		t.Errorf("event order should not matter, got %v", changes)
+	}
+	existing.Active = false
+	existing.Config.InsecureSSL = nil
+	existing.Config.ContentType = "form"
+	if changes := diffWebHook(existing, desired); len(changes) != 2 {
+		t.Errorf("expected active and content_type changes, got %v", changes)
+	}
+}
+
+func Test_DesiredWebHookDefaultSecret(t *testing.T) {
+	config := ConfigGithub{Endpoint: "/webhook", PublicAddress: "http://localhost", Secret: defaultSecret}
+	if secret := config.desiredWebHook().Config.Secret; secret != "" {
+		t.Errorf("default secret should not be sent to github, got %v", secret)
+	}
+	config.Secret = "own"
+	if secret := config.desiredWebHook().Config.Secret; secret != "own" {
+		t.Errorf("%v should be own", secret)
+	}
+}
+
+func Test_ReconcileWebHook(t *testing.T) {
+	setupTestlogging()
+	var patched *WebHookUpdate
+	created := false
+	var server *httptest.Server
+	server = newTestGithubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Query().Get("page") == "":
+			w.Header().Set("Link", fmt.Sprintf("<%v/repos/owner/repo/hooks?page=2>; rel=\"next\"", server.URL))
+			fmt.Fprintf(w, `[{"id":1,"name":"web","active":true,"events":["push"],"config":{"url":"http://other/webhook"}}]`)
+		case r.Method == http.MethodGet:
+			fmt.Fprintf(w, `[{"id":2,"name":"web","active":true,"events":["pull_request"],"config":{"url":"http://localhost/webhook","content_type":"json","insecure_ssl":"0"}}]`)
+		case r.Method == http.MethodPatch && r.URL.Path == "/repos/owner/repo/hooks/2":
+			patched = new(WebHookUpdate)
+			if err := json.NewDecoder(r.Body).Decode(patched); err != nil {
+				t.Error(err)
+			}
+			fmt.Fprintf(w, `{"id":2}`)
+		case r.Method == http.MethodPost:
+			created = true
+			fmt.Fprintf(w, `{"id":3}`)
+		}
+	})
+	c := &Crawler{Config: ConfigGithub{APIURL: server.URL, Endpoint: "/webhook", PublicAddress: "http://localhost"}}
+	c.Config.Webhook.Reconcile = true
+	if err := c.updateWebHooks("owner/repo"); err != nil {
+		t.Fatal(err)
+	}
+	if created {
+		t.Errorf("hook on second page should be updated, not created")
+	}
+	if patched == nil {
+		t.Fatal("expected hook to be patched")
+	}
+	if len(patched.Events) != 2 || !patched.Active {
+		t.Errorf("unexpected update %+v", patched)
+	}
+}
+
+func Test_CreateInactiveWebHook(t *testing.T) {
+	setupTestlogging()
+	var posted map[string]any
+	server := newTestGithubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&posted); err != nil {
+			t.Error(err)
+		}
+		fmt.Fprintf(w, `{"id":3}`)
+	})
+	c := &Crawler{Config: ConfigGithub{APIURL: server.URL, Endpoint: "/webhook", PublicAddress: "http://localhost"}}
+	inactive := false
+	c.Config.Webhook.Active = &inactive
+	if _, err := c.createWebHook(c.client().url("/repos/owner/repo/hooks"), c.Config.desiredWebHook()); err != nil {
+		t.Fatal(err)
+	}
+	if active, found := posted["active"]; !found || active != false {
+		t.Errorf("active false should be sent, got %v", posted)
+	}
+}
+
+func Test_RotateWebHookSecret(t *testing.T) {
+	setupTestlogging()
+	var patched *WebHookUpdate
+	server := newTestGithubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			fmt.Fprintf(w, `[{"id":2,"name":"web","active":true,"events":["pull_request","pull_request_review"],"config":{"url":"http://localhost/webhook","content_type":"json","insecure_ssl":"0","secret":"********"}}]`)
+		case http.MethodPatch:
+			patched = new(WebHookUpdate)
+			if err := json.NewDecoder(r.Body).Decode(patched); err != nil {
+				t.Error(err)
+			}
+			fmt.Fprintf(w, `{"id":2}`)
+		default:
+			t.Errorf("unexpected %v %v", r.Method, r.URL)
+		}
+	})
+	c := &Crawler{Config: ConfigGithub{APIURL: server.URL, Endpoint: "/webhook", PublicAddress: "http://localhost", Secret: "new"}, Rotation: NewSecretRotation()}
+	c.Config.Webhook.Reconcile = true
+	if err := c.updateWebHooks("owner/repo"); err != nil {
+		t.Fatal(err)
+	}
+	if patched != nil {
+		t.Fatalf("matching hook should not be patched")
+	}
+	c.Rotation.markPrevious("owner/repo")
+	if err := c.updateWebHooks("owner/repo"); err != nil {
+		t.Fatal(err)
+	}
+	if patched == nil || patched.Config.Secret != "new" {
+		t.Fatalf("hook using the previous secret should be patched with the new secret, got %+v", patched)
+	}
+	if c.Rotation.usesPrevious("owner/repo") {
+		t.Errorf("owner/repo should be marked current after patching")
+	}
+}
+
+func Test_RotateEveryWebHook(t *testing.T) {
+	setupTestlogging()
+	var patches []*WebHookUpdate
+	server := newTestGithubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			fmt.Fprintf(w, `[{"id":2,"name":"web","active":true,"events":["push"],"config":{"url":"http://localhost/webhook","content_type":"json","insecure_ssl":"0","secret":"********"}}]`)
+		case http.MethodPatch:
+			patch := new(WebHookUpdate)
+			if err := json.NewDecoder(r.Body).Decode(patch); err != nil {
+				t.Error(err)
+			}
+			patches = append(patches, patch)
+			fmt.Fprintf(w, `{"id":2}`)
+		default:
+			t.Errorf("unexpected %v %v", r.Method, r.URL)
+		}
+	})
+	config := ConfigGithub{APIURL: server.URL, Endpoint: "/webhook", PublicAddress: "http://localhost", Secret: "new", PreviousSecret: "old"}
+	store := &FileCheckpointStore{Path: filepath.Join(t.TempDir(), "checkpoint.json")}
+	c := &Crawler{Config: config, Checkpoints: store, Rotation: NewSecretRotation()}
+	c.loadCheckpoint()
+	for range 2 {
+		if err := c.updateWebHooks("owner/repo"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(patches) != 1 {
+		t.Fatalf("hook should be patched once during the rotation, got %v patches", len(patches))
+	}
+	if patches[0].Config.Secret != "new" || !slices.Equal(patches[0].Events, []string{"push"}) {
+		t.Errorf("without reconcile only the secret should change, got %+v", patches[0])
+	}
+	// a restart during the rotation reads the rotated hooks from the checkpoint
+	c = &Crawler{Config: config, Checkpoints: store, Rotation: NewSecretRotation()}
+	c.loadCheckpoint()
+	if err := c.updateWebHooks("owner/repo"); err != nil {
+		t.Fatal(err)
+	}
+	if len(patches) != 1 {
+		t.Errorf("hook should not be patched again after a restart, got %v patches", len(patches))
+	}
+	// a new rotation patches the hook again
+	config.Secret, config.PreviousSecret = "newer", "new"
+	c = &Crawler{Config: config, Checkpoints: store, Rotation: NewSecretRotation()}
+	c.loadCheckpoint()
+	if err := c.updateWebHooks("owner/repo"); err != nil {
+		t.Fatal(err)
+	}
+	if len(patches) != 2 || patches[1].Config.Secret != "newer" {
+		t.Errorf("hook should be patched once in the next rotation, got %v patches", len(patches))
+	}
+}
+
+func Test_OrgWebHook(t *testing.T) {
+	setupTestlogging()
+	requests := []string{}
+	orgFailing := true
+	server := newTestGithubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch {
+		case r.URL.Path == "/orgs/Example-Org/hooks" && orgFailing:
+			w.WriteHeader(http.StatusForbidden)
+		case r.Method == http.MethodGet && r.URL.Path == "/repos/example-org/repo/hooks":
+			fmt.Fprintf(w, `[{"id":5,"config":{"url":"http://localhost/webhook"}},{"id":6,"config":{"url":"http://other/webhook"}}]`)
+		case r.Method == http.MethodGet:
+			fmt.Fprintf(w, `[]`)
+		case r.Method == http.MethodPost:
+			fmt.Fprintf(w, `{"id":1}`)
+		case r.Method == http.MethodPatch:
+			fmt.Fprintf(w, `{"id":5}`)
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	c := &Crawler{Config: ConfigGithub{APIURL: server.URL, Endpoint: "/webhook", PublicAddress: "http://localhost"}}
+	c.Config.Webhook.Organizations = []string{"Example-Org"}
+	c.Config.Webhook.Reconcile = true
+	c.updateOrgWebHooks()
+	requests = nil
+	if err := c.updateWebHooks("example-org/repo"); err != nil {
+		t.Fatal(err)
+	}
+	if slices.Contains(requests, "DELETE /repos/example-org/repo/hooks/5") {
+		t.Errorf("repository hook should be kept while the organization hook is missing, got %v", requests)
+	}
+	orgFailing = false
+	requests = nil
+	c.updateOrgWebHooks()
+	wanted := []string{"GET /orgs/Example-Org/hooks", "POST /orgs/Example-Org/hooks"}
+	if !slices.Equal(requests, wanted) {
+		t.Errorf("%v should be %v", requests, wanted)
+	}
+	c.Config.Webhook.Reconcile = false
+	requests = nil
+	if err := c.updateWebHooks("example-org/repo"); err != nil {
+		t.Fatal(err)
+	}
+	wanted = []string{"GET /repos/example-org/repo/hooks"}
+	if !slices.Equal(requests, wanted) {
+		t.Errorf("without reconcile a duplicate hook should only be logged, got %v", requests)
+	}
+	c.Config.Webhook.Reconcile = true
+	c.orgCovered = nil
+	requests = nil
+	if err := c.updateWebHooks("example-org/repo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.updateWebHooks("example-org/repo"); err != nil {
+		t.Fatal(err)
+	}
+	wanted = []string{"GET /repos/example-org/repo/hooks", "DELETE /repos/example-org/repo/hooks/5"}
+	if !slices.Equal(requests, wanted) {
+		t.Errorf("duplicate repository hook should be deleted once, got %v", requests)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"flag"
@@ -9,11 +10,16 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/elastic/go-elasticsearch/v9"
 	"github.com/elastic/go-elasticsearch/v9/esapi"
+	"github.com/elastic/go-elasticsearch/v9/esutil"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -26,29 +32,32 @@ var (
 	debugLogger    *slog.Logger
 	configFileName string
 	config         *ConfigType
-	tenMinuteTick  = time.NewTicker(10 * time.Minute)
 	quit           = make(chan struct{})
-	ratelimit_used = promauto.NewGauge(prometheus.GaugeOpts{
+	// shutdownTimeout is how long running webhook handlers get to finish
+	shutdownTimeout = 30 * time.Second
+	ratelimit_used  = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "ratelimit_used",
 		Help: "Ratelimit Used Statistics",
-	})
-	ratelimit_remaining = promauto.NewGauge(prometheus.GaugeOpts{
+	}, []string{"resource"})
+	ratelimit_remaining = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "ratelimit_remaining",
 		Help: "Ratelimit Remaining Statistics",
-	})
-	ratelimit_total = promauto.NewGauge(prometheus.GaugeOpts{
+	}, []string{"resource"})
+	ratelimit_total = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "ratelimit_total",
 		Help: "Ratelimit Total Statistics",
-	})
-	ratelimit_reset = promauto.NewGauge(prometheus.GaugeOpts{
+	}, []string{"resource"})
+	ratelimit_reset = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "ratelimit_reset",
 		Help: "Ratelimit Seconds Till Reset",
-	})
+	}, []string{"resource"})
 )
 
 const (
 	BaseENVname = "HOOK"
 	webhookPath = "/webhook"
+	// defaultSecret is public, deliveries signed with it could be forged by anyone
+	defaultSecret = "application-github-webhook-test"
 )
 
 type ConfigType struct {
@@ -57,6 +66,11 @@ type ConfigType struct {
 	Prometheus ConfigPrometheus `mapstructure:"prometheus"`
 	Elastic    *ConfigElastic   `mapstructure:"elastic"`
 	Github     ConfigGithub     `mapstructure:"github"`
+	Checkpoint ConfigCheckpoint `mapstructure:"checkpoint"`
+	// Outputs events are written to, any of elastic, kafka and nats
+	Outputs []string    `mapstructure:"outputs"`
+	Kafka   ConfigKafka `mapstructure:"kafka"`
+	NATS    ConfigNATS  `mapstructure:"nats"`
 }
 type ConfigLogging struct {
 	Level  string `mapstructure:"level"`
@@ -67,12 +81,28 @@ type ConfigPrometheus struct {
 	Endpoint string `mapstructure:"endpoint"`
 }
 type ConfigGithub struct {
-	Secret          string `mapstructure:"secret"`
-	Endpoint        string `mapstructure:"endpoint"`
-	PublicAddress   string `mapstructure:"public_address"`
-	PRPageSize      int    `mapstructure:"pr_page_size"`
-	WebhookPageSize int    `mapstructure:"webhook_page_size"`
-	Token           string `mapstructure:"token"`
+	Secret          string             `mapstructure:"secret"`
+	PreviousSecret  string             `mapstructure:"previous_secret"`
+	PreviousExpires string             `mapstructure:"previous_secret_expires"`
+	Endpoint        string             `mapstructure:"endpoint"`
+	PublicAddress   string             `mapstructure:"public_address"`
+	PRPageSize      int                `mapstructure:"pr_page_size"`
+	WebhookPageSize int                `mapstructure:"webhook_page_size"`
+	Token           string             `mapstructure:"token"`
+	APIURL          string             `mapstructure:"api_url"`
+	UploadURL       string             `mapstructure:"upload_url"`
+	GraphQLURL      string             `mapstructure:"graphql_url"`
+	AppID           int64              `mapstructure:"app_id"`
+	PrivateKey      string             `mapstructure:"private_key"`
+	PrivateKeyPath  string             `mapstructure:"private_key_path"`
+	CrawlEvents     []string           `mapstructure:"crawl_events"`
+	Webhook         ConfigWebhook      `mapstructure:"webhook"`
+	Repositories    ConfigRepositories `mapstructure:"repositories"`
+	Schedule        ConfigSchedule     `mapstructure:"schedule"`
+	RateLimit       ConfigRateLimit    `mapstructure:"ratelimit"`
+	Cache           ConfigCache        `mapstructure:"cache"`
+	// Backend for crawling pull requests, rest or graphql
+	Backend string `mapstructure:"backend"`
 }
 
 func (c *ConfigGithub) populateEnv() {
@@ -80,10 +110,52 @@ func (c *ConfigGithub) populateEnv() {
 	if envSecret != "" {
 		c.Secret = envSecret
 	}
+	envPreviousSecret := os.Getenv(BaseENVname + "_GITHUB_PREVIOUS_SECRET")
+	if envPreviousSecret != "" {
+		c.PreviousSecret = envPreviousSecret
+	}
 	envToken := os.Getenv(BaseENVname + "_GITHUB_TOKEN")
 	if envToken != "" {
 		c.Token = envToken
 	}
+	envAppID := os.Getenv(BaseENVname + "_GITHUB_APP_ID")
+	if envAppID != "" {
+		appID, err := strconv.ParseInt(envAppID, 10, 64)
+		if err != nil {
+			panic(fmt.Errorf("fatal error parsing %v: %w", BaseENVname+"_GITHUB_APP_ID", err))
+		}
+		c.AppID = appID
+	}
+	envPrivateKey := os.Getenv(BaseENVname + "_GITHUB_PRIVATE_KEY")
+	if envPrivateKey != "" {
+		c.PrivateKey = envPrivateKey
+	}
+}
+
+// previousSecretExpires is the end of the grace window for the previous secret, zero means no end
+func (c *ConfigGithub) previousSecretExpires() time.Time {
+	if c.PreviousExpires == "" {
+		return time.Time{}
+	}
+	expires, err := time.Parse(time.RFC3339, c.PreviousExpires)
+	if err != nil {
+		logger.Error("error parsing github.previous_secret_expires", "error", err)
+		os.Exit(1)
+	}
+	return expires
+}
+
+// crawlEvent tells if an event type (pull_request, pull_request_review, issues, workflow_run, workflow_job) should be crawled, pull requests are crawled by default
+func (c *ConfigGithub) crawlEvent(event string) bool {
+	if c.CrawlEvents == nil {
+		return event == "pull_request"
+	}
+	for _, crawlEvent := range c.CrawlEvents {
+		if crawlEvent == event {
+			return true
+		}
+	}
+	return false
 }
 
 func (c *ConfigGithub) getWebHookURL() string {
@@ -103,6 +175,8 @@ func (c *ConfigGithub) getWebHookURL() string {
 }
 
 type ConfigElastic struct {
+	// Type of cluster, elasticsearch or opensearch
+	Type              string   `mapstructure:"type"`
 	Addresses         []string `mapstructure:"addresses"`
 	Username          string   `mapstructure:"username"`
 	Password          string   `mapstructure:"password"`
@@ -110,6 +184,20 @@ type ConfigElastic struct {
 	EnableMetrics     bool     `mapstructure:"enableMetrics"`
 	EnableDebugLogger bool     `mapstructure:"enableDebugLogging"`
 	Index             string   `mapstructure:"index"`
+	// DataStream, like logs-github.pullrequest-default, is written to instead of Index when set
+	DataStream string      `mapstructure:"data_stream"`
+	Bulk       ConfigBulk  `mapstructure:"bulk"`
+	Setup      ConfigSetup `mapstructure:"setup"`
+	// Routes pick the index or data stream per event, events no route matches go to the target
+	Routes []ConfigRoute `mapstructure:"routes"`
+}
+
+// target is the data stream or index documents are written to
+func (c *ConfigElastic) target() string {
+	if c.DataStream != "" {
+		return c.DataStream
+	}
+	return c.Index
 }
 
 func (c *ConfigElastic) populateEnv() {
@@ -130,18 +218,28 @@ func (cfg *ConfigElastic) getConfig() *elasticsearch.Config {
 		Password:          cfg.Password,
 		EnableMetrics:     cfg.EnableMetrics,
 		EnableDebugLogger: cfg.EnableDebugLogger,
+		RetryOnStatus:     []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+		MaxRetries:        cfg.Bulk.MaxRetries,
+		RetryBackoff: func(attempt int) time.Duration {
+			return time.Duration(1<<attempt) * time.Second
+		},
 	}
-	if cfg.CACert != "" {
-		sDec, err := base64.StdEncoding.DecodeString(cfg.CACert)
-		if err != nil {
-			logger.Error("error decoding base64", "error", err)
-			os.Exit(1)
-		}
-		config.CACert = sDec
-	}
+	config.CACert = cfg.caCert()
 	return config
 }
 
+func (cfg *ConfigElastic) caCert() []byte {
+	if cfg.CACert == "" {
+		return nil
+	}
+	sDec, err := base64.StdEncoding.DecodeString(cfg.CACert)
+	if err != nil {
+		logger.Error("error decoding base64", "error", err)
+		os.Exit(1)
+	}
+	return sDec
+}
+
 func ConfigRead(configFileName string, configOutput *ConfigType) *viper.Viper {
 	configReader := viper.New()
 	configReader.SetConfigName(configFileName)
@@ -154,15 +252,53 @@ func ConfigRead(configFileName string, configOutput *ConfigType) *viper.Viper {
 	configReader.SetDefault("port", 8080)
 	configReader.SetDefault("prometheus.enabled", true)
 	configReader.SetDefault("prometheus.endpoint", "/metrics")
+	configReader.SetDefault("elastic.type", "elasticsearch")
 	configReader.SetDefault("elastic.addresses", []string{"http://localhost:9200"})
 	configReader.SetDefault("elastic.username", "github-hook")
 	configReader.SetDefault("elastic.enableMetrics", true)
 	configReader.SetDefault("elastic.enableDebugLogging", true)
 	configReader.SetDefault("elastic.index", "application-github-webhook-test")
-	configReader.SetDefault("github.secret", "application-github-webhook-test")
+	configReader.SetDefault("elastic.bulk.workers", 1)
+	configReader.SetDefault("elastic.bulk.flush_bytes", 5*1024*1024)
+	configReader.SetDefault("elastic.bulk.flush_interval", "30s")
+	configReader.SetDefault("elastic.bulk.max_retries", 5)
+	configReader.SetDefault("elastic.setup.rollover_max_age", "30d")
+	configReader.SetDefault("elastic.setup.rollover_max_primary_shard_size", "50gb")
+	configReader.SetDefault("github.secret", defaultSecret)
 	configReader.SetDefault("github.endpoint", "/webhook")
 	configReader.SetDefault("github.pr_page_size", 50)
 	configReader.SetDefault("github.webhook_page_size", 0)
+	configReader.SetDefault("github.api_url", defaultAPIURL)
+	configReader.SetDefault("github.upload_url", defaultUploadURL)
+	configReader.SetDefault("github.graphql_url", defaultGraphQLURL)
+	configReader.SetDefault("github.crawl_events", []string{"pull_request"})
+	configReader.SetDefault("github.webhook.events", defaultWebHookEvents)
+	configReader.SetDefault("github.webhook.content_type", "json")
+	configReader.SetDefault("github.webhook.insecure_ssl", false)
+	configReader.SetDefault("github.webhook.active", true)
+	configReader.SetDefault("github.webhook.reconcile", false)
+	configReader.SetDefault("github.webhook.monitor", true)
+	configReader.SetDefault("github.webhook.redeliver", false)
+	configReader.SetDefault("github.webhook.redeliver_lookback", "1h")
+	configReader.SetDefault("github.schedule.interval", "10m")
+	configReader.SetDefault("github.schedule.batch_size", 1)
+	configReader.SetDefault("github.schedule.workers", 1)
+	configReader.SetDefault("github.ratelimit.reserve", 2000)
+	configReader.SetDefault("github.ratelimit.secondary_backoff", "1m")
+	configReader.SetDefault("github.cache.enabled", true)
+	configReader.SetDefault("github.cache.max_entries", 10000)
+	configReader.SetDefault("github.cache.max_bytes", 64<<20)
+	configReader.SetDefault("github.backend", "rest")
+	configReader.SetDefault("checkpoint.type", "none")
+	configReader.SetDefault("checkpoint.path", "checkpoint.json")
+	configReader.SetDefault("checkpoint.index", "application-github-webhook-state")
+	configReader.SetDefault("checkpoint.id", "crawler")
+	configReader.SetDefault("outputs", []string{"elastic"})
+	configReader.SetDefault("kafka.topic", "github-events")
+	configReader.SetDefault("kafka.timeout", "30s")
+	configReader.SetDefault("nats.url", "nats://localhost:4222")
+	configReader.SetDefault("nats.subject", "github.events")
+	configReader.SetDefault("nats.timeout", "30s")
 
 	err := configReader.ReadInConfig() // Find and read the config file
 	if err != nil {                    // Handle errors reading the config file
@@ -214,8 +350,28 @@ func main() {
 	ConfigRead(configFileName, config)
 	config.Github.populateEnv()
 	config.Elastic.populateEnv()
+	config.Kafka.populateEnv()
 	setupLogging(config.Logging)
-	search := initSearch(config.Elastic)
+	switch flag.Arg(0) {
+	case "":
+	case "uninstall-webhooks":
+		runUninstallWebHooks(flag.Args()[1:])
+		return
+	case "backfill":
+		runBackfill(flag.Args()[1:])
+		return
+	case "setup":
+		runSetup(flag.Args()[1:])
+		return
+	default:
+		logger.Error("unknown command", "command", flag.Arg(0))
+		os.Exit(1)
+	}
+	if config.Github.Secret == defaultSecret || config.Github.PreviousSecret == defaultSecret {
+		logger.Error("github.secret is the default secret, set github.secret or HOOK_GITHUB_SECRET to a secret of your own")
+		os.Exit(1)
+	}
+	sink := initSinks(config)
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		w.Write([]byte("{\"Status\": \"UP\"}"))
@@ -226,63 +382,156 @@ func main() {
 	if config.Prometheus.Enabled {
 		http.Handle(config.Prometheus.Endpoint, promhttp.Handler())
 	}
-	crawler := Crawler{Config: config.Github, ES: search}
+	if config.Github.Secret == "" {
+		logger.Warn("github.secret not configured, webhook deliveries will not be verified")
+	}
+	rotation := NewSecretRotation()
+	handler := &WebhookHandler{Secret: config.Github.Secret, Sink: sink, Rotation: rotation}
+	if config.Github.PreviousSecret != "" {
+		handler.PreviousSecret = config.Github.PreviousSecret
+		handler.PreviousExpires = config.Github.previousSecretExpires()
+		logger.Info("accepting previous webhook secret", "expires", handler.PreviousExpires)
+	}
+	http.Handle(config.Github.Endpoint, handler)
+	if config.Github.Backend != "rest" && config.Github.Backend != "graphql" {
+		logger.Error("unknown github.backend", "backend", config.Github.Backend)
+		os.Exit(1)
+	}
+	filter, err := NewRepositoryFilter(config.Github.Repositories)
+	if err != nil {
+		logger.Error("error in github.repositories", "error", err)
+		os.Exit(1)
+	}
+	crawler := &Crawler{Config: config.Github, Sink: sink, Client: NewGithubClient(config.Github), Checkpoints: config.Checkpoint.getStore(sink), Rotation: rotation, Filter: filter}
+	crawler.loadCheckpoint()
 
 	//crawler.Tick()
-	defer close(quit)
-	go Ticker(crawler)
+	tickerDone := make(chan struct{})
+	go func() {
+		defer close(tickerDone)
+		Ticker(crawler)
+	}()
 
 	portString := fmt.Sprintf(":%v", config.Port)
-	logger.Info("listeining on port " + portString)
-	http.ListenAndServe(portString, nil)
+	server := &http.Server{Addr: portString}
+	go func() {
+		logger.Info("listeining on port " + portString)
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			logger.Error("error serving http", "error", err)
+			os.Exit(1)
+		}
+	}()
+	shutdownOnSignal(server, tickerDone, sink, crawler)
 }
 
-func Ticker(crawler Crawler) {
+func shuttingDown() bool {
+	select {
+	case <-quit:
+		return true
+	default:
+		return false
+	}
+}
+
+func Ticker(crawler *Crawler) {
+	ticker := time.NewTicker(crawler.Config.Schedule.interval())
 	for {
 		select {
-		case <-tenMinuteTick.C:
+		case <-ticker.C:
 			debugLogger.Debug("------------------------------ Tick Started ------------------------------")
 			crawler.Tick()
 		case <-quit:
 			logger.Info("ending ticker")
-			tenMinuteTick.Stop()
+			ticker.Stop()
 			return
 		}
 	}
 }
 
+// shutdownOnSignal stops the ticker, waits for running webhook handlers and the running tick, and flushes
+// queued documents before exiting. Repositories with documents failing in the flush are held in the checkpoint
+func shutdownOnSignal(server *http.Server, tickerDone <-chan struct{}, sink Sink, crawler *Crawler) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-sigs
+	logger.Info("shutting down", "signal", sig.String())
+	close(quit)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Error("error stopping http server", "error", err)
+	}
+	<-tickerDone
+	err := sink.Close()
+	crawler.holdFailedRepositories()
+	if err != nil {
+		logger.Error("error flushing documents", "error", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
 func printESError(message string, res *esapi.Response) {
 	bodyText, err := io.ReadAll(res.Body)
 	if err != nil {
 		logger.Error("error reading body", "error", err)
 	}
-	var e map[string]interface{}
+	var e struct {
+		Error struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	}
 	err = json.Unmarshal(bodyText, &e)
 	if err != nil {
-		logger.Error("error unmarshaling body", "body", bodyText, "error", err)
+		logger.Error(message, "status", res.Status(), "body", bodyText)
+		return
 	}
-	logger.Error(message, "status", res.Status(), "type", e["error"].(map[string]interface{})["type"], "reason", e["error"].(map[string]interface{})["reason"])
+	logger.Error(message, "status", res.Status(), "type", e.Error.Type, "reason", e.Error.Reason)
 }
 
 type Search struct {
-	esClient *elasticsearch.Client
-	index    string
+	esClient   *elasticsearch.Client
+	index      string
+	indexer    esutil.BulkIndexer
+	maxRetries int
+	retries    sync.WaitGroup
+	// mu guards closed, documents are refused once the indexer is closing
+	mu     sync.RWMutex
+	closed bool
+	// dataStream documents get an @timestamp
+	dataStream bool
+	router     *Router
+	failures   failedRepositories
 }
 
-func initSearch(config *ConfigElastic) *Search {
+// newSearch creates the client without checking the index or starting the indexer
+func newSearch(config *ConfigElastic) *Search {
 	var err error
-	search := &Search{index: config.Index}
+	search := &Search{index: config.target(), dataStream: config.DataStream != ""}
 	search.esClient, err = elasticsearch.NewClient(*config.getConfig())
 	if err != nil {
 		logger.Error("error staring elasticsearch client", "error", err)
 		os.Exit(1)
 	}
-	res, err := search.esClient.Indices.Exists([]string{config.Index})
+	return search
+}
+
+func initSearch(config *ConfigElastic) *Search {
+	search := newSearch(config)
+	router, err := NewRouter(config.Routes, config.target(), config.DataStream != "")
+	if err != nil {
+		logger.Error("error in elastic.routes", "error", err)
+		os.Exit(1)
+	}
+	search.router = router
+	search.startIndexer(config.Bulk)
+	res, err := search.esClient.Indices.Exists([]string{config.target()})
 	if err != nil {
 		logger.Error("error checking indice exists", "error", err)
 	}
 	if res.StatusCode == http.StatusNotFound {
-		printESError("Indice does not exist, is webhook installed?", res)
+		logger.Error("Indice does not exist, create it with the setup command", "index", config.target())
 		os.Exit(3)
 	} else {
 		if res.StatusCode == http.StatusOK {
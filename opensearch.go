@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// openSearchQueue documents are held while a batch is sent, when full events are refused instead of
+	// blocking webhook deliveries
+	openSearchQueue = 1000
+	// defaultFlushInterval matches the elasticsearch bulk indexer
+	defaultFlushInterval = 30 * time.Second
+)
+
+var ErrQueueFull = errors.New("error, queue full")
+
+// OpenSearch writes events with plain _bulk requests, the elasticsearch client refuses clusters that do not
+// identify as elasticsearch
+type OpenSearch struct {
+	client     *http.Client
+	addresses  []string
+	username   string
+	password   string
+	index      string
+	dataStream bool
+	router     *Router
+	maxRetries int
+	flushBytes int
+	backoff    func(attempt int) time.Duration
+	documents  chan *bulkDocument
+	done       chan struct{}
+	failures   failedRepositories
+	// mu guards closed, documents are refused once the queue is closed
+	mu     sync.RWMutex
+	closed bool
+}
+
+func newOpenSearch(config *ConfigElastic) *OpenSearch {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caCert := config.caCert(); caCert != nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			logger.Error("error reading elastic.cacert")
+			os.Exit(1)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return &OpenSearch{
+		client:     &http.Client{Transport: transport, Timeout: time.Minute},
+		addresses:  config.Addresses,
+		username:   config.Username,
+		password:   config.Password,
+		index:      config.target(),
+		dataStream: config.DataStream != "",
+		maxRetries: config.Bulk.MaxRetries,
+		flushBytes: config.Bulk.FlushBytes,
+		backoff: func(attempt int) time.Duration {
+			return time.Duration(1<<attempt) * time.Second
+		},
+	}
+}
+
+func initOpenSearch(config *ConfigElastic) *OpenSearch {
+	search := newOpenSearch(config)
+	router, err := NewRouter(config.Routes, config.target(), config.DataStream != "")
+	if err != nil {
+		logger.Error("error in elastic.routes", "error", err)
+		os.Exit(1)
+	}
+	search.router = router
+	res, err := search.request(http.MethodHead, "/"+config.target(), nil)
+	if err != nil {
+		logger.Error("error checking indice exists", "error", err)
+		os.Exit(4)
+	}
+	res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		logger.Info("Indice exists, starting")
+	case http.StatusNotFound:
+		logger.Error("Indice does not exist", "index", config.target())
+		os.Exit(3)
+	case http.StatusUnauthorized:
+		logger.Error("OpenSearch Connection Unauthorized", "status", res.Status)
+		os.Exit(-1)
+	default:
+		logger.Error("Unknown response", "status", res.Status)
+		os.Exit(4)
+	}
+	search.start(config.Bulk.FlushInterval)
+	return search
+}
+
+// request tries the addresses in order until one answers
+func (s *OpenSearch) request(method string, path string, body []byte) (*http.Response, error) {
+	var lastErr error
+	for _, address := range s.addresses {
+		req, err := http.NewRequest(method, strings.TrimSuffix(address, "/")+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/x-ndjson")
+		}
+		if s.username != "" {
+			req.SetBasicAuth(s.username, s.password)
+		}
+		res, err := s.client.Do(req)
+		if err != nil {
+			debugLogger.Debug("opensearch request failed", "address", address, "error", err)
+			lastErr = err
+			continue
+		}
+		return res, nil
+	}
+	return nil, lastErr
+}
+
+// start runs the worker sending queued documents when flushBytes is reached or every flushInterval
+func (s *OpenSearch) start(flushInterval time.Duration) {
+	if flushInterval <= 0 {
+		flushInterval = defaultFlushInterval
+	}
+	s.documents = make(chan *bulkDocument, openSearchQueue)
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		var batch []*bulkDocument
+		size := 0
+		for {
+			select {
+			case document, ok := <-s.documents:
+				if !ok {
+					s.flush(batch)
+					return
+				}
+				batch = append(batch, document)
+				size += len(document.body)
+				if size >= s.flushBytes {
+					s.flush(batch)
+					batch, size = nil, 0
+				}
+			case <-ticker.C:
+				s.flush(batch)
+				batch, size = nil, 0
+			}
+		}
+	}()
+}
+
+func (s *OpenSearch) createEvent(eventType string, repository string, documentID string, event any, body []byte, attrs ...any) error {
+	index, dataStream := s.router.target(eventType, repository, event)
+	if index == "" {
+		index, dataStream = s.index, s.dataStream
+	}
+	if dataStream {
+		var err error
+		body, err = withTimestamp(body, event)
+		if err != nil {
+			return err
+		}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return ErrSinkClosed
+	}
+	select {
+	case s.documents <- &bulkDocument{index: index, repository: repository, documentID: documentID, body: body, attrs: attrs}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// flush sends a batch, resending documents rejected with a 429 or 5xx until maxRetries
+func (s *OpenSearch) flush(batch []*bulkDocument) {
+	for attempt := 0; len(batch) > 0; attempt++ {
+		if attempt > 0 {
+			backoff := s.backoff(attempt)
+			debugLogger.Debug("retrying documents", "count", len(batch), "attempt", attempt, "backoff", backoff)
+			elastic_documents_retried.Add(float64(len(batch)))
+			time.Sleep(backoff)
+		}
+		batch = s.send(batch, attempt < s.maxRetries)
+	}
+}
+
+type openSearchBulkResponse struct {
+	Items []map[string]struct {
+		Index  string `json:"_index"`
+		ID     string `json:"_id"`
+		Status int    `json:"status"`
+		Error  struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// send does one _bulk request and returns the documents to resend
+func (s *OpenSearch) send(batch []*bulkDocument, retry bool) []*bulkDocument {
+	var body bytes.Buffer
+	for _, document := range batch {
+		fmt.Fprintf(&body, `{"create":{"_index":%q,"_id":%q}}`+"\n", document.index, document.documentID)
+		body.Write(bytes.TrimSpace(document.body))
+		body.WriteByte('\n')
+	}
+	failBatch := func(attrs ...any) []*bulkDocument {
+		if retry {
+			return batch
+		}
+		for _, document := range batch {
+			elastic_documents.WithLabelValues("failed").Inc()
+			s.failures.add(document.repository)
+			logger.Error("error pushing document", document.logAttrs(append([]any{"documentID", document.documentID}, attrs...)...)...)
+		}
+		return nil
+	}
+	res, err := s.request(http.MethodPost, "/_bulk", body.Bytes())
+	if err != nil {
+		return failBatch("error", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= http.StatusInternalServerError {
+		return failBatch("status", res.StatusCode)
+	}
+	bodyText, err := io.ReadAll(res.Body)
+	if err != nil {
+		return failBatch("error", err)
+	}
+	var response openSearchBulkResponse
+	if err := json.Unmarshal(bodyText, &response); err != nil || len(response.Items) != len(batch) {
+		retry = false
+		return failBatch("status", res.StatusCode, "body", string(bodyText))
+	}
+	var resend []*bulkDocument
+	for idx, document := range batch {
+		for _, item := range response.Items[idx] {
+			switch {
+			case item.Status >= 200 && item.Status < 300:
+				elastic_documents.WithLabelValues("created").Inc()
+				logger.Info("Pushed document", document.logAttrs("index", item.Index, "documentID", item.ID)...)
+			case item.Status == http.StatusConflict:
+				elastic_documents.WithLabelValues("conflict").Inc()
+				debugLogger.Debug("Pushed document - Already exists", document.logAttrs("documentID", document.documentID)...)
+			case retry && (item.Status == http.StatusTooManyRequests || item.Status >= http.StatusInternalServerError):
+				document.attempt += 1
+				resend = append(resend, document)
+			default:
+				elastic_documents.WithLabelValues("failed").Inc()
+				s.failures.add(document.repository)
+				logger.Error("error pushing document", document.logAttrs("documentID", document.documentID, "status", item.Status, "type", item.Error.Type, "reason", item.Error.Reason)...)
+			}
+		}
+	}
+	return resend
+}
+
+func (s *OpenSearch) failedRepositories() []string {
+	return s.failures.take()
+}
+
+// Close sends what is left in the queue
+func (s *OpenSearch) Close() error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.documents)
+	}
+	s.mu.Unlock()
+	<-s.done
+	return nil
+}
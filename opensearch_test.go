@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_OpenSearchBulk(t *testing.T) {
+	setupTestlogging()
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	indices := map[string]string{}
+	bodies := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+			t.Errorf("unexpected request %v %v", r.Method, r.URL.Path)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		items := []string{}
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var meta map[string]struct {
+				Index string `json:"_index"`
+				ID    string `json:"_id"`
+			}
+			if err := json.Unmarshal(scanner.Bytes(), &meta); err != nil {
+				t.Error(err)
+			}
+			create := meta["create"]
+			attempts[create.ID] += 1
+			indices[create.ID] = create.Index
+			status := http.StatusCreated
+			switch {
+			case create.ID == "exists":
+				status = http.StatusConflict
+			case create.ID == "busy" && attempts[create.ID] == 1:
+				status = http.StatusTooManyRequests
+			case create.ID == "broken":
+				status = http.StatusBadRequest
+			}
+			items = append(items, fmt.Sprintf(`{"create":{"_index":%q,"_id":%q,"status":%v}}`, create.Index, create.ID, status))
+			scanner.Scan()
+			bodies[create.ID] = scanner.Text()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"took":1,"errors":true,"items":[%v]}`, strings.Join(items, ","))
+	}))
+	defer server.Close()
+	router, err := NewRouter([]ConfigRoute{{Events: []string{"issues"}, Target: "github-issues", DataStream: true}}, "github", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	search := newOpenSearch(&ConfigElastic{Addresses: []string{server.URL}, Index: "github", Bulk: ConfigBulk{MaxRetries: 1, FlushBytes: 1 << 20}})
+	search.router = router
+	search.backoff = func(attempt int) time.Duration { return time.Millisecond }
+	search.start(time.Hour)
+	created, conflict, failed := documentCount(t, "created"), documentCount(t, "conflict"), documentCount(t, "failed")
+	for _, id := range []string{"new", "exists", "busy", "broken"} {
+		if err := search.createEvent("pull_request", "owner/repo", id, &PullRequestEvent{}, []byte(`{}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := search.createEvent("issues", "owner/repo", "issue", &IssuesEvent{}, []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := search.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if documentCount(t, "created")-created != 3 {
+		t.Errorf("expected 3 created documents")
+	}
+	if documentCount(t, "conflict")-conflict != 1 {
+		t.Errorf("expected 1 conflicting document")
+	}
+	if documentCount(t, "failed")-failed != 1 {
+		t.Errorf("expected 1 failed document")
+	}
+	if attempts["busy"] != 2 || attempts["new"] != 1 {
+		t.Errorf("only the rejected document should be resent, attempts %v", attempts)
+	}
+	if indices["issue"] != "github-issues" || indices["new"] != "github" {
+		t.Errorf("unexpected routing %v", indices)
+	}
+	if !strings.Contains(bodies["issue"], "@timestamp") || strings.Contains(bodies["new"], "@timestamp") {
+		t.Errorf("only documents routed to a data stream should get an @timestamp, got %v", bodies)
+	}
+	if err := search.createEvent("issues", "owner/repo", "late", &IssuesEvent{}, []byte(`{}`)); err != ErrSinkClosed {
+		t.Errorf("%v should be %v", err, ErrSinkClosed)
+	}
+}
+
+func Test_OpenSearchQueueFull(t *testing.T) {
+	setupTestlogging()
+	search := newOpenSearch(&ConfigElastic{Index: "github"})
+	search.documents = make(chan *bulkDocument, 1)
+	if err := search.createEvent("issues", "owner/repo", "first", &IssuesEvent{}, []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := search.createEvent("issues", "owner/repo", "second", &IssuesEvent{}, []byte(`{}`)); err != ErrQueueFull {
+		t.Errorf("%v should be %v", err, ErrQueueFull)
+	}
+}
@@ -116,29 +116,42 @@ type PullRequest struct {
 	Assignees      *[]User    `json:"assignees,omitempty"`
 	Draft          bool       `json:"draft"`
 
-	RequestedReviewers  []User    `json:"requested_reviewers,omitempty"`
-	Labels              []Label   `json:"labels,omitempty"`
-	RequestedTeam       *Team     `json:"requested_team,omitempty"`
-	Head                Reference `json:"head"`
-	Base                Reference `json:"base"`
-	AuthorAssociation   string    `json:"author_association"`
-	Merged              bool      `json:"merged"`
-	Mergeable           *bool     `json:"mergeable"`
-	Rebaseable          bool      `json:"rebaseable"`
-	MergeableState      string    `json:"mergeable_state"`
-	MergedBy            *User     `json:"merged_by"`
-	Comments            int64     `json:"comments"`
-	ReviewComments      int64     `json:"review_comments"`
-	MaintainerCanModify bool      `json:"maintainer_can_modify"`
-	Commits             int64     `json:"commits"`
-	Additions           int64     `json:"additions"`
-	Deletions           int64     `json:"deletions"`
-	ChangedFiles        int64     `json:"changed_files"`
+	RequestedReviewers  []User     `json:"requested_reviewers,omitempty"`
+	Labels              []Label    `json:"labels,omitempty"`
+	RequestedTeam       *Team      `json:"requested_team,omitempty"`
+	Head                Reference  `json:"head"`
+	Base                Reference  `json:"base"`
+	AuthorAssociation   string     `json:"author_association"`
+	Merged              bool       `json:"merged"`
+	Mergeable           *bool      `json:"mergeable"`
+	Rebaseable          bool       `json:"rebaseable"`
+	MergeableState      string     `json:"mergeable_state"`
+	MergedBy            *User      `json:"merged_by"`
+	Comments            int64      `json:"comments"`
+	ReviewComments      int64      `json:"review_comments"`
+	MaintainerCanModify bool       `json:"maintainer_can_modify"`
+	Commits             int64      `json:"commits"`
+	Additions           int64      `json:"additions"`
+	Deletions           int64      `json:"deletions"`
+	ChangedFiles        int64      `json:"changed_files"`
+	Milestone           *Milestone `json:"milestone,omitempty"`
+	// Timeline is only filled by the graphql backend, in the shape of the REST timeline api
+	Timeline []TimelineEvent `json:"timeline,omitempty"`
+}
+
+type TimelineEvent struct {
+	Event     string    `json:"event"`
+	Actor     User      `json:"actor"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 func (pr *PullRequest) generateUUID() string {
+	return generateUUID(fmt.Sprintf("%v%v%v%v", pr.Base.Repo.FullName, pr.ID, pr.Number, pr.State))
+}
+
+// generateUUID makes a deterministic document id so the same state is only indexed once
+func generateUUID(generateString string) string {
 	h := md5.New()
-	generateString := fmt.Sprintf("%v%v%v%v", pr.Base.Repo.FullName, pr.ID, pr.Number, pr.State)
 	h.Write([]byte(generateString))
 	bs := h.Sum(nil)
 	u, err := uuid.FromBytes(bs)
@@ -149,6 +162,21 @@ func (pr *PullRequest) generateUUID() string {
 	return u.String()
 }
 
+// timedEvent is an event that knows when it happened on GitHub, timestamp is when it was received or crawled
+type timedEvent interface {
+	occurredAt() time.Time
+}
+
+// eventTime is when event happened, now for events that do not know
+func eventTime(event any) time.Time {
+	if timed, ok := event.(timedEvent); ok {
+		if at := timed.occurredAt(); !at.IsZero() {
+			return at
+		}
+	}
+	return time.Now()
+}
+
 type PullRequestEvent struct {
 	Timestamp   time.Time    `json:"timestamp"`
 	Action      string       `json:"action"`
@@ -192,7 +220,318 @@ type Team struct {
 	Permission  string `json:"permission"`
 }
 
+func (pr *PullRequestEvent) occurredAt() time.Time {
+	if pr.PullRequest == nil {
+		return time.Time{}
+	}
+	return pr.PullRequest.UpdatedAt
+}
+
 func (pr *PullRequestEvent) parse() ([]byte, error) {
 	pr.Timestamp = time.Now()
 	return json.Marshal(pr)
 }
+
+type Issue struct {
+	URL               string     `json:"url"`
+	RepositoryURL     string     `json:"repository_url"`
+	HTMLURL           string     `json:"html_url"`
+	ID                int64      `json:"id"`
+	NodeID            string     `json:"node_id"`
+	Number            int64      `json:"number"`
+	Title             string     `json:"title"`
+	User              User       `json:"user"`
+	Labels            []Label    `json:"labels,omitempty"`
+	State             string     `json:"state"`
+	StateReason       *string    `json:"state_reason"`
+	Locked            bool       `json:"locked"`
+	Assignee          *User      `json:"assignee,omitempty"`
+	Assignees         *[]User    `json:"assignees,omitempty"`
+	Milestone         *Milestone `json:"milestone,omitempty"`
+	Comments          int64      `json:"comments"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+	ClosedAt          *time.Time `json:"closed_at"`
+	ClosedBy          *User      `json:"closed_by,omitempty"`
+	AuthorAssociation string     `json:"author_association"`
+	Body              string     `json:"body"`
+	PullRequest       *struct {
+		URL      string     `json:"url"`
+		HTMLURL  string     `json:"html_url"`
+		MergedAt *time.Time `json:"merged_at"`
+	} `json:"pull_request,omitempty"`
+}
+
+type IssuesEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	Action    string    `json:"action"`
+	Issue     *Issue    `json:"issue"`
+	Changes   *struct {
+		Title *struct {
+			From string `json:"from"`
+		} `json:"title"`
+		Body *struct {
+			From string `json:"from"`
+		} `json:"body"`
+	} `json:"changes"`
+	Repository   Repository `json:"repository"`
+	Label        *Label     `json:"label,omitempty"`
+	Sender       User       `json:"sender"`
+	Assignee     *User      `json:"assignee,omitempty"`
+	Milestone    *Milestone `json:"milestone,omitempty"`
+	Installation struct {
+		ID int64 `json:"id"`
+	} `json:"installation"`
+}
+
+// the issues api has no repository object so it is passed in from the crawler
+func (issue *Issue) toIssuesEvent(repository Repository) (*IssuesEvent, error) {
+	ie := &IssuesEvent{
+		Timestamp:  issue.CreatedAt,
+		Action:     "periodic_pull",
+		Issue:      issue,
+		Repository: repository,
+		Sender:     issue.User,
+		Assignee:   issue.Assignee,
+	}
+	return ie, nil
+}
+
+func (ie *IssuesEvent) generateUUID() string {
+	return generateUUID(fmt.Sprintf("issue%v%v%v%v", ie.Repository.FullName, ie.Issue.ID, ie.Issue.Number, ie.Issue.State))
+}
+
+func (ie *IssuesEvent) occurredAt() time.Time {
+	if ie.Issue == nil {
+		return time.Time{}
+	}
+	return ie.Issue.UpdatedAt
+}
+
+func (ie *IssuesEvent) parse() ([]byte, error) {
+	ie.Timestamp = time.Now()
+	return json.Marshal(ie)
+}
+
+type PullRequestReview struct {
+	ID                int64      `json:"id"`
+	NodeID            string     `json:"node_id"`
+	User              User       `json:"user"`
+	Body              string     `json:"body"`
+	State             string     `json:"state"`
+	HTMLURL           string     `json:"html_url"`
+	PullRequestURL    string     `json:"pull_request_url"`
+	SubmittedAt       *time.Time `json:"submitted_at"`
+	CommitID          string     `json:"commit_id"`
+	AuthorAssociation string     `json:"author_association"`
+}
+
+type PullRequestReviewEvent struct {
+	Timestamp    time.Time          `json:"timestamp"`
+	Action       string             `json:"action"`
+	Review       *PullRequestReview `json:"review"`
+	PullRequest  *PullRequest       `json:"pull_request"`
+	Repository   Repository         `json:"repository"`
+	Sender       User               `json:"sender"`
+	Installation struct {
+		ID int64 `json:"id"`
+	} `json:"installation"`
+}
+
+func (review *PullRequestReview) toPullRequestReviewEvent(pr *PullRequest) (*PullRequestReviewEvent, error) {
+	pre := &PullRequestReviewEvent{
+		Timestamp:   *review.SubmittedAt,
+		Action:      "periodic_pull",
+		Review:      review,
+		PullRequest: pr,
+		Repository:  pr.Base.Repo,
+		Sender:      review.User,
+	}
+	return pre, nil
+}
+
+func (pre *PullRequestReviewEvent) generateUUID() string {
+	return generateUUID(fmt.Sprintf("review%v%v%v%v", pre.Repository.FullName, pre.Review.ID, pre.PullRequest.Number, pre.Review.State))
+}
+
+func (pre *PullRequestReviewEvent) occurredAt() time.Time {
+	if pre.Review == nil || pre.Review.SubmittedAt == nil {
+		return time.Time{}
+	}
+	return *pre.Review.SubmittedAt
+}
+
+func (pre *PullRequestReviewEvent) parse() ([]byte, error) {
+	pre.Timestamp = time.Now()
+	return json.Marshal(pre)
+}
+
+type WorkflowRun struct {
+	ID              int64      `json:"id"`
+	NodeID          string     `json:"node_id"`
+	Name            string     `json:"name"`
+	DisplayTitle    string     `json:"display_title"`
+	HeadBranch      string     `json:"head_branch"`
+	HeadSha         string     `json:"head_sha"`
+	Path            string     `json:"path"`
+	RunNumber       int64      `json:"run_number"`
+	RunAttempt      int64      `json:"run_attempt"`
+	Event           string     `json:"event"`
+	Status          string     `json:"status"`
+	Conclusion      *string    `json:"conclusion"`
+	WorkflowID      int64      `json:"workflow_id"`
+	CheckSuiteID    int64      `json:"check_suite_id"`
+	URL             string     `json:"url"`
+	HTMLURL         string     `json:"html_url"`
+	Actor           *User      `json:"actor,omitempty"`
+	TriggeringActor *User      `json:"triggering_actor,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	RunStartedAt    *time.Time `json:"run_started_at"`
+	PullRequests    []struct {
+		ID     int64  `json:"id"`
+		Number int64  `json:"number"`
+		URL    string `json:"url"`
+	} `json:"pull_requests,omitempty"`
+}
+
+type WorkflowRuns struct {
+	TotalCount   int           `json:"total_count"`
+	WorkflowRuns []WorkflowRun `json:"workflow_runs"`
+}
+
+type WorkflowStep struct {
+	Name        string     `json:"name"`
+	Status      string     `json:"status"`
+	Conclusion  *string    `json:"conclusion"`
+	Number      int64      `json:"number"`
+	StartedAt   *time.Time `json:"started_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+type WorkflowJob struct {
+	ID              int64          `json:"id"`
+	RunID           int64          `json:"run_id"`
+	RunAttempt      int64          `json:"run_attempt"`
+	NodeID          string         `json:"node_id"`
+	Name            string         `json:"name"`
+	WorkflowName    string         `json:"workflow_name"`
+	HeadBranch      string         `json:"head_branch"`
+	HeadSha         string         `json:"head_sha"`
+	URL             string         `json:"url"`
+	HTMLURL         string         `json:"html_url"`
+	Status          string         `json:"status"`
+	Conclusion      *string        `json:"conclusion"`
+	CreatedAt       time.Time      `json:"created_at"`
+	StartedAt       *time.Time     `json:"started_at"`
+	CompletedAt     *time.Time     `json:"completed_at"`
+	Steps           []WorkflowStep `json:"steps,omitempty"`
+	Labels          []string       `json:"labels"`
+	RunnerName      *string        `json:"runner_name"`
+	RunnerGroupName *string        `json:"runner_group_name"`
+}
+
+type WorkflowJobs struct {
+	TotalCount int           `json:"total_count"`
+	Jobs       []WorkflowJob `json:"jobs"`
+}
+
+type WorkflowRunEvent struct {
+	Timestamp       time.Time    `json:"timestamp"`
+	Action          string       `json:"action"`
+	WorkflowRun     *WorkflowRun `json:"workflow_run"`
+	DurationSeconds float64      `json:"duration_seconds,omitempty"`
+	Repository      Repository   `json:"repository"`
+	Sender          *User        `json:"sender,omitempty"`
+	Installation    struct {
+		ID int64 `json:"id"`
+	} `json:"installation"`
+}
+
+type WorkflowJobEvent struct {
+	Timestamp       time.Time    `json:"timestamp"`
+	Action          string       `json:"action"`
+	WorkflowJob     *WorkflowJob `json:"workflow_job"`
+	DurationSeconds float64      `json:"duration_seconds,omitempty"`
+	Repository      Repository   `json:"repository"`
+	Sender          *User        `json:"sender,omitempty"`
+	Installation    struct {
+		ID int64 `json:"id"`
+	} `json:"installation"`
+}
+
+// duration is only known once the run has completed
+func (run *WorkflowRun) duration() time.Duration {
+	if run.Status != "completed" || run.RunStartedAt == nil {
+		return 0
+	}
+	return run.UpdatedAt.Sub(*run.RunStartedAt)
+}
+
+func (run *WorkflowRun) toWorkflowRunEvent(repository Repository) (*WorkflowRunEvent, error) {
+	wre := &WorkflowRunEvent{
+		Timestamp:       run.CreatedAt,
+		Action:          "periodic_pull",
+		WorkflowRun:     run,
+		DurationSeconds: run.duration().Seconds(),
+		Repository:      repository,
+		Sender:          run.TriggeringActor,
+	}
+	return wre, nil
+}
+
+func (wre *WorkflowRunEvent) generateUUID() string {
+	return generateUUID(fmt.Sprintf("workflow_run%v%v%v%v", wre.Repository.FullName, wre.WorkflowRun.ID, wre.WorkflowRun.RunAttempt, wre.WorkflowRun.Status))
+}
+
+func (wre *WorkflowRunEvent) occurredAt() time.Time {
+	if wre.WorkflowRun == nil {
+		return time.Time{}
+	}
+	return wre.WorkflowRun.UpdatedAt
+}
+
+func (wre *WorkflowRunEvent) parse() ([]byte, error) {
+	wre.Timestamp = time.Now()
+	return json.Marshal(wre)
+}
+
+func (job *WorkflowJob) duration() time.Duration {
+	if job.Status != "completed" || job.StartedAt == nil || job.CompletedAt == nil {
+		return 0
+	}
+	return job.CompletedAt.Sub(*job.StartedAt)
+}
+
+func (job *WorkflowJob) toWorkflowJobEvent(repository Repository, sender *User) (*WorkflowJobEvent, error) {
+	wje := &WorkflowJobEvent{
+		Timestamp:       job.CreatedAt,
+		Action:          "periodic_pull",
+		WorkflowJob:     job,
+		DurationSeconds: job.duration().Seconds(),
+		Repository:      repository,
+		Sender:          sender,
+	}
+	return wje, nil
+}
+
+func (wje *WorkflowJobEvent) generateUUID() string {
+	return generateUUID(fmt.Sprintf("workflow_job%v%v%v%v", wje.Repository.FullName, wje.WorkflowJob.ID, wje.WorkflowJob.RunAttempt, wje.WorkflowJob.Status))
+}
+
+func (wje *WorkflowJobEvent) occurredAt() time.Time {
+	switch {
+	case wje.WorkflowJob == nil:
+		return time.Time{}
+	case wje.WorkflowJob.CompletedAt != nil:
+		return *wje.WorkflowJob.CompletedAt
+	case wje.WorkflowJob.StartedAt != nil:
+		return *wje.WorkflowJob.StartedAt
+	}
+	return wje.WorkflowJob.CreatedAt
+}
+
+func (wje *WorkflowJobEvent) parse() ([]byte, error) {
+	wje.Timestamp = time.Now()
+	return json.Marshal(wje)
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"net/http"
 	"testing"
+	"time"
 )
 
 func Test_ParseJson(t *testing.T) {
@@ -56,3 +57,40 @@ func Test_Test_UUID_Generation(t *testing.T) {
 	}
 	t.Logf("uuid: %v", uuid)
 }
+
+func Test_IssueUUID(t *testing.T) {
+	setupTestlogging()
+	issue := &Issue{ID: 1, Number: 2, State: "open"}
+	event, _ := issue.toIssuesEvent(Repository{FullName: "owner/repo"})
+	pr := &PullRequest{ID: 1, Number: 2, State: "open", Base: Reference{Repo: Repository{FullName: "owner/repo"}}}
+	if event.generateUUID() == pr.generateUUID() {
+		t.Errorf("issue and pull request with same id should not share document id")
+	}
+	if event.generateUUID() != event.generateUUID() {
+		t.Errorf("uuid should be deterministic")
+	}
+}
+
+func Test_WorkflowRunUUID(t *testing.T) {
+	setupTestlogging()
+	started := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	run := &WorkflowRun{ID: 1, RunAttempt: 1, Status: "in_progress", RunStartedAt: &started, UpdatedAt: started.Add(90 * time.Second)}
+	event, _ := run.toWorkflowRunEvent(Repository{FullName: "owner/repo"})
+	if event.DurationSeconds != 0 {
+		t.Errorf("running workflow should not have a duration, got %v", event.DurationSeconds)
+	}
+	inProgress := event.generateUUID()
+	run.Status = "completed"
+	event, _ = run.toWorkflowRunEvent(Repository{FullName: "owner/repo"})
+	if event.DurationSeconds != 90 {
+		t.Errorf("duration should be 90, got %v", event.DurationSeconds)
+	}
+	completed := event.generateUUID()
+	if completed == inProgress {
+		t.Errorf("status change should give a new document id")
+	}
+	run.RunAttempt = 2
+	if event.generateUUID() == completed {
+		t.Errorf("new attempt should give a new document id")
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ConfigRateLimit struct {
+	// Reserve is left for webhook management and other users of the token
+	Reserve int `mapstructure:"reserve"`
+	// SecondaryBackoff is the wait after a secondary rate limit without Retry-After
+	SecondaryBackoff time.Duration `mapstructure:"secondary_backoff"`
+}
+
+// rateLimits tracks the limits per resource (core, search, graphql), shared by all copies of a client
+// as they spend the same budget
+type rateLimits struct {
+	mu               sync.Mutex
+	resources        map[string]*RateLimit
+	until            time.Time
+	calls            int
+	secondaryBackoff time.Duration
+}
+
+func newRateLimits(secondaryBackoff time.Duration) *rateLimits {
+	if secondaryBackoff <= 0 {
+		secondaryBackoff = time.Minute
+	}
+	return &rateLimits{resources: make(map[string]*RateLimit), secondaryBackoff: secondaryBackoff}
+}
+
+// update records the limits of a response, charged is false for 304s which GitHub does not count
+func (l *rateLimits) update(ratelimit *RateLimit, charged bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if charged {
+		l.calls += 1
+	}
+	l.resources[ratelimit.Resource] = ratelimit
+}
+
+func (l *rateLimits) resource(resource string) *RateLimit {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.resources[resource]
+}
+
+// spent is the number of requests made with the client
+func (l *rateLimits) spent() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.calls
+}
+
+// blockedUntil is when requests may be sent again after being rate limited, zero when not blocked
+func (l *rateLimits) blockedUntil() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if time.Now().After(l.until) {
+		return time.Time{}
+	}
+	return l.until
+}
+
+// block stops requests after a rate limited response. Retry-After is honoured first, then the reset
+// of an exhausted limit, otherwise it was a secondary rate limit
+func (l *rateLimits) block(header http.Header, ratelimit *RateLimit) time.Time {
+	until := time.Now().Add(l.secondaryBackoff)
+	if seconds, err := strconv.Atoi(header.Get("Retry-After")); err == nil {
+		until = time.Now().Add(time.Duration(seconds) * time.Second)
+	} else if ratelimit.Remaining != nil && *ratelimit.Remaining == 0 && ratelimit.Reset != nil {
+		until = *ratelimit.Reset
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until.After(l.until) {
+		l.until = until
+	}
+	return l.until
+}
+
+// budget is how many calls of a resource can be spent this tick to make the remaining calls,
+// less the reserve, last until the reset. Without a known limit the budget is unlimited
+func (l *rateLimits) budget(resource string, interval time.Duration, reserve int) int {
+	ratelimit := l.resource(resource)
+	if ratelimit == nil || ratelimit.Remaining == nil {
+		return math.MaxInt
+	}
+	if ratelimit.Reset != nil && time.Now().After(*ratelimit.Reset) {
+		return math.MaxInt
+	}
+	available := *ratelimit.Remaining - reserve
+	if available <= 0 {
+		return 0
+	}
+	ticks := 1
+	if ratelimit.Reset != nil {
+		ticks += int(time.Until(*ratelimit.Reset) / interval)
+	}
+	return max(available/ticks, 1)
+}
+
+// rateLimited tells if a response is a primary or secondary rate limit rather than a permission error
+func rateLimited(statusCode int, header http.Header, body []byte) bool {
+	if statusCode == http.StatusTooManyRequests {
+		return true
+	}
+	if statusCode != http.StatusForbidden {
+		return false
+	}
+	return header.Get("Retry-After") != "" ||
+		header.Get("X-Ratelimit-Remaining") == "0" ||
+		strings.Contains(strings.ToLower(string(body)), "rate limit")
+}
+
+func (g *GithubClient) getRateLimits(header http.Header, statusCode int) *RateLimit {
+	ratelimit := &RateLimit{
+		Resource:  header.Get("X-Ratelimit-Resource"),
+		Used:      convertVar(header, "X-Ratelimit-Used"),
+		Remaining: convertVar(header, "X-Ratelimit-Remaining"),
+		Total:     convertVar(header, "X-Ratelimit-Limit"),
+	}
+	if ratelimit.Resource == "" {
+		ratelimit.Resource = "core"
+	}
+	if resetValue := header.Get("X-Ratelimit-Reset"); resetValue != "" {
+		resetInt, err := strconv.ParseInt(resetValue, 10, 64)
+		if err != nil {
+			logger.Error("error ParseInt", "variable", "X-Ratelimit-Reset", "value", resetValue, "error", err)
+		} else {
+			resetTime := time.Unix(resetInt, 0)
+			ratelimit.Reset = &resetTime
+		}
+	}
+	if ratelimit.Remaining != nil {
+		g.limits.update(ratelimit, statusCode != http.StatusNotModified)
+	}
+	ratelimit.setGauges()
+	return ratelimit
+}
+
+// convertVar reads an integer header, nil when the header is missing
+func convertVar(header http.Header, variable string) *int {
+	value := header.Get(variable)
+	if value == "" {
+		return nil
+	}
+	res, err := strconv.Atoi(value)
+	if err != nil {
+		logger.Error("error converting", "variable", variable, "value", value, "error", err)
+		return nil
+	}
+	return &res
+}
+
+type RateLimit struct {
+	Resource  string     `json:"resource,omitempty"`
+	Used      *int       `json:"used,omitempty"`
+	Remaining *int       `json:"remaining,omitempty"`
+	Total     *int       `json:"total,omitempty"`
+	Reset     *time.Time `json:"reset,omitempty"`
+}
+
+func (r *RateLimit) String() string {
+	return fmt.Sprintf("resource: %v, used: %v, remaining: %v, total: %v, resets: %v", r.Resource, intString(r.Used), intString(r.Remaining), intString(r.Total), r.Reset)
+}
+
+func intString(i *int) string {
+	if i == nil {
+		return "unknown"
+	}
+	return strconv.Itoa(*i)
+}
+
+func (r *RateLimit) setGauges() {
+	if r.Used != nil {
+		ratelimit_used.WithLabelValues(r.Resource).Set(float64(*r.Used))
+	}
+	if r.Remaining != nil {
+		ratelimit_remaining.WithLabelValues(r.Resource).Set(float64(*r.Remaining))
+	}
+	if r.Total != nil {
+		ratelimit_total.WithLabelValues(r.Resource).Set(float64(*r.Total))
+	}
+	if r.Reset != nil {
+		ratelimit_reset.WithLabelValues(r.Resource).Set(float64(r.Reset.Unix() - time.Now().Unix()))
+	}
+}
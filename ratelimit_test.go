@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_RateLimitBudget(t *testing.T) {
+	limits := newRateLimits(0)
+	if budget := limits.budget("core", 10*time.Minute, 2000); budget <= 0 {
+		t.Errorf("unknown limit should not stop crawling, got %v", budget)
+	}
+	remaining := 3000
+	reset := time.Now().Add(55 * time.Minute)
+	limits.update(&RateLimit{Resource: "core", Remaining: &remaining, Reset: &reset}, true)
+	if budget := limits.budget("core", 10*time.Minute, 2000); budget != 1000/6 {
+		t.Errorf("budget should be spread over 6 ticks, got %v", budget)
+	}
+	if budget := limits.budget("core", 10*time.Minute, 3000); budget != 0 {
+		t.Errorf("budget should be exhausted, got %v", budget)
+	}
+	limits.update(&RateLimit{Resource: "core", Remaining: &remaining, Reset: &reset}, false)
+	if limits.spent() != 1 {
+		t.Errorf("304s should not be counted, spent %v", limits.spent())
+	}
+}
+
+func Test_SecondaryRateLimit(t *testing.T) {
+	setupTestlogging()
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests += 1
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"message":"You have exceeded a secondary rate limit."}`))
+	}))
+	t.Cleanup(server.Close)
+	client := NewGithubClient(ConfigGithub{APIURL: server.URL})
+	if _, err := client.get(client.url("/user/repos"), nil); err != ErrRateLimited {
+		t.Fatalf("secondary rate limit should be %v, got %v", ErrRateLimited, err)
+	}
+	until := client.limits.blockedUntil()
+	if until.Before(time.Now().Add(25*time.Second)) || until.After(time.Now().Add(35*time.Second)) {
+		t.Errorf("Retry-After should block for 30 seconds, got %v", until)
+	}
+	if _, err := client.get(client.url("/user/repos"), nil); err != ErrRateLimited || requests != 1 {
+		t.Errorf("blocked client should not send requests, got %v after %v requests", err, requests)
+	}
+}
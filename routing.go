@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// ConfigRoute sends events matching Events and Repositories to the index or data stream Target expands to,
+// like github-{{.Action}}-{{.Repository.Owner.Login}}-{{date "2006.01"}}. Empty matchers match everything
+type ConfigRoute struct {
+	Events       []string `mapstructure:"events"`
+	Repositories []string `mapstructure:"repositories"`
+	Target       string   `mapstructure:"target"`
+	// DataStream is set when Target is a data stream, documents then get an @timestamp
+	DataStream bool `mapstructure:"data_stream"`
+}
+
+type route struct {
+	config ConfigRoute
+	target *template.Template
+}
+
+// Router picks the target of an event from the first matching route, falling back to the configured index
+type Router struct {
+	routes             []route
+	fallback           string
+	fallbackDataStream bool
+}
+
+var routeFuncs = template.FuncMap{
+	// date is replaced per event by one formatting the time of that event
+	"date":  formatDate(time.Time{}),
+	"lower": strings.ToLower,
+}
+
+// formatDate formats at in UTC, so a document lands in the index of the day it happened not the day it was sent
+func formatDate(at time.Time) func(layout string) string {
+	return func(layout string) string {
+		return at.UTC().Format(layout)
+	}
+}
+
+func NewRouter(routes []ConfigRoute, fallback string, fallbackDataStream bool) (*Router, error) {
+	r := &Router{fallback: fallback, fallbackDataStream: fallbackDataStream}
+	for idx, config := range routes {
+		for _, glob := range config.Repositories {
+			if _, err := path.Match(glob, ""); err != nil {
+				return nil, err
+			}
+		}
+		target, err := template.New(fmt.Sprintf("route%v", idx)).Funcs(routeFuncs).Option("missingkey=error").Parse(config.Target)
+		if err != nil {
+			return nil, err
+		}
+		r.routes = append(r.routes, route{config: config, target: target})
+	}
+	return r, nil
+}
+
+func (r *route) matches(eventType string, repository string) bool {
+	if len(r.config.Events) > 0 && !slices.Contains(r.config.Events, eventType) {
+		return false
+	}
+	if len(r.config.Repositories) == 0 {
+		return true
+	}
+	for _, glob := range r.config.Repositories {
+		if matched, _ := path.Match(glob, repository); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// target expands the first matching route against the event, index names are always lower case.
+// Also returns if the target is a data stream
+func (r *Router) target(eventType string, repository string, event any) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+	for _, route := range r.routes {
+		if !route.matches(eventType, repository) {
+			continue
+		}
+		var target strings.Builder
+		tmpl, err := route.target.Clone()
+		if err == nil {
+			err = tmpl.Funcs(template.FuncMap{"date": formatDate(eventTime(event))}).Execute(&target, event)
+		}
+		if err != nil {
+			logger.Error("error expanding route target, using default", "event", eventType, "repository", repository, "error", err)
+			return r.fallback, r.fallbackDataStream
+		}
+		return strings.ToLower(target.String()), route.config.DataStream
+	}
+	return r.fallback, r.fallbackDataStream
+}
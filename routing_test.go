@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_RouterTarget(t *testing.T) {
+	setupTestlogging()
+	router, err := NewRouter([]ConfigRoute{
+		{Events: []string{"workflow_run"}, Repositories: []string{"Team/*"}, Target: `github-{{.Action}}-{{.Repository.Owner.Login}}-{{date "2006.01"}}`},
+		{Events: []string{"issues"}, Target: "{{.Missing}}"},
+		{Repositories: []string{"other/*"}, Target: "github-other"},
+		{Events: []string{"pull_request_review"}, Target: "logs-github.reviews", DataStream: true},
+	}, "github", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	updated := time.Date(2025, 12, 31, 23, 0, 0, 0, time.UTC)
+	run := &WorkflowRunEvent{Action: "completed", WorkflowRun: &WorkflowRun{UpdatedAt: updated}, Repository: Repository{FullName: "Team/repo", Owner: User{Login: "Team"}}}
+	tests := []struct {
+		eventType  string
+		repository string
+		event      any
+		wanted     string
+		dataStream bool
+	}{
+		{"workflow_run", "Team/repo", run, "github-completed-team-2025.12", false},
+		{"workflow_run", "Team2/repo", run, "github", false},
+		{"issues", "Team/repo", &IssuesEvent{}, "github", false},
+		{"pull_request", "other/repo", &PullRequestEvent{}, "github-other", false},
+		{"pull_request", "Team/repo", &PullRequestEvent{}, "github", false},
+		{"pull_request_review", "Team/repo", &PullRequestReviewEvent{}, "logs-github.reviews", true},
+	}
+	for _, test := range tests {
+		if target, dataStream := router.target(test.eventType, test.repository, test.event); target != test.wanted || dataStream != test.dataStream {
+			t.Errorf("%v %v routed to %v (data stream %v), should be %v (data stream %v)", test.eventType, test.repository, target, dataStream, test.wanted, test.dataStream)
+		}
+	}
+	if _, err := NewRouter([]ConfigRoute{{Target: "{{.Action"}}, "github", false); err == nil {
+		t.Errorf("invalid template should be rejected")
+	}
+	if _, err := NewRouter([]ConfigRoute{{Repositories: []string{"["}, Target: "github"}}, "github", false); err == nil {
+		t.Errorf("invalid glob should be rejected")
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import "time"
+
+type ConfigSchedule struct {
+	// Interval between ticks, each tick crawls BatchSize repositories with up to Workers at a time
+	Interval  time.Duration `mapstructure:"interval"`
+	BatchSize int           `mapstructure:"batch_size"`
+	Workers   int           `mapstructure:"workers"`
+}
+
+func (c *ConfigSchedule) interval() time.Duration {
+	if c.Interval <= 0 {
+		return 10 * time.Minute
+	}
+	return c.Interval
+}
+
+func (c *ConfigSchedule) batchSize() int {
+	return max(c.BatchSize, 1)
+}
+
+func (c *ConfigSchedule) workers() int {
+	return max(c.Workers, 1)
+}
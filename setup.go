@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+
+	"github.com/elastic/go-elasticsearch/v9/esapi"
+)
+
+type ConfigSetup struct {
+	// Template and Policy default to the index name
+	Template        string `mapstructure:"template"`
+	Policy          string `mapstructure:"policy"`
+	RolloverMaxAge  string `mapstructure:"rollover_max_age"`
+	RolloverMaxSize string `mapstructure:"rollover_max_primary_shard_size"`
+	// DeleteAfter removes indices this long after rollover, kept forever when empty
+	DeleteAfter string `mapstructure:"delete_after"`
+}
+
+// eventMappings are the mappings of the indexed events, strings not listed are keywords
+const eventMappings = `{
+  "dynamic_templates": [
+    {"strings_as_keywords": {"match_mapping_type": "string", "mapping": {"type": "keyword", "ignore_above": 1024}}}
+  ],
+  "properties": {
+    "timestamp": {"type": "date"},
+    "action": {"type": "keyword"},
+    "number": {"type": "long"},
+    "pull_request": {
+      "properties": {
+        "id": {"type": "long"},
+        "number": {"type": "long"},
+        "state": {"type": "keyword"},
+        "title": {"type": "text", "fields": {"keyword": {"type": "keyword", "ignore_above": 1024}}},
+        "body": {"type": "text"},
+        "draft": {"type": "boolean"},
+        "merged": {"type": "boolean"},
+        "created_at": {"type": "date"},
+        "updated_at": {"type": "date"},
+        "closed_at": {"type": "date"},
+        "merged_at": {"type": "date"},
+        "user": {"properties": {"login": {"type": "keyword"}, "id": {"type": "long"}}},
+        "merged_by": {"properties": {"login": {"type": "keyword"}, "id": {"type": "long"}}},
+        "labels": {"properties": {"name": {"type": "keyword"}}},
+        "head": {"properties": {"ref": {"type": "keyword"}, "sha": {"type": "keyword"}}},
+        "base": {"properties": {"ref": {"type": "keyword"}, "sha": {"type": "keyword"}, "repo": {"properties": {"full_name": {"type": "keyword"}}}}},
+        "additions": {"type": "long"},
+        "deletions": {"type": "long"},
+        "changed_files": {"type": "long"},
+        "commits": {"type": "long"},
+        "comments": {"type": "long"},
+        "review_comments": {"type": "long"}
+      }
+    },
+    "review": {
+      "properties": {
+        "id": {"type": "long"},
+        "state": {"type": "keyword"},
+        "body": {"type": "text"},
+        "submitted_at": {"type": "date"},
+        "user": {"properties": {"login": {"type": "keyword"}, "id": {"type": "long"}}}
+      }
+    },
+    "issue": {
+      "properties": {
+        "number": {"type": "long"},
+        "state": {"type": "keyword"},
+        "title": {"type": "text", "fields": {"keyword": {"type": "keyword", "ignore_above": 1024}}},
+        "body": {"type": "text"},
+        "created_at": {"type": "date"},
+        "updated_at": {"type": "date"},
+        "closed_at": {"type": "date"},
+        "labels": {"properties": {"name": {"type": "keyword"}}}
+      }
+    },
+    "duration_seconds": {"type": "double"},
+    "repository": {"properties": {"full_name": {"type": "keyword"}, "name": {"type": "keyword"}, "owner": {"properties": {"login": {"type": "keyword"}}}}},
+    "sender": {"properties": {"login": {"type": "keyword"}}}
+  }
+}`
+
+func (c *ConfigElastic) templateName() string {
+	if c.Setup.Template != "" {
+		return c.Setup.Template
+	}
+	return c.target()
+}
+
+func (c *ConfigElastic) policyName() string {
+	if c.Setup.Policy != "" {
+		return c.Setup.Policy
+	}
+	return c.target()
+}
+
+func (c *ConfigElastic) desiredPolicy() map[string]any {
+	phases := map[string]any{
+		"hot": map[string]any{
+			"min_age": "0ms",
+			"actions": map[string]any{
+				"rollover": map[string]any{
+					"max_age":                c.Setup.RolloverMaxAge,
+					"max_primary_shard_size": c.Setup.RolloverMaxSize,
+				},
+			},
+		},
+	}
+	if c.Setup.DeleteAfter != "" {
+		phases["delete"] = map[string]any{
+			"min_age": c.Setup.DeleteAfter,
+			"actions": map[string]any{"delete": map[string]any{"delete_searchable_snapshot": true}},
+		}
+	}
+	return map[string]any{"phases": phases}
+}
+
+func (c *ConfigElastic) desiredTemplate() map[string]any {
+	var mappings map[string]any
+	if err := json.Unmarshal([]byte(eventMappings), &mappings); err != nil {
+		panic(err)
+	}
+	lifecycle := map[string]any{"name": c.policyName()}
+	template := map[string]any{
+		"index_patterns": []any{c.Index + "-*"},
+		// above the built in logs-*-* template
+		"priority": 200,
+		"template": map[string]any{
+			"settings": map[string]any{"index": map[string]any{"lifecycle": lifecycle}},
+			"mappings": mappings,
+		},
+	}
+	if c.DataStream != "" {
+		template["index_patterns"] = []any{c.DataStream}
+		// the defaults elasticsearch fills in, so an unchanged template is not reported as changed
+		template["data_stream"] = map[string]any{"hidden": false, "allow_custom_routing": false}
+		mappings["properties"].(map[string]any)["@timestamp"] = map[string]any{"type": "date"}
+	} else {
+		lifecycle["rollover_alias"] = c.Index
+	}
+	return template
+}
+
+// runSetup is the setup command, installing the ilm policy, index template and write alias
+func runSetup(args []string) {
+	flags := flag.NewFlagSet("setup", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "Report differences without changing anything")
+	flags.Parse(args)
+	if config.Elastic.Type == "opensearch" {
+		logger.Error("setup supports elastic.type elasticsearch only, opensearch uses ism policies")
+		os.Exit(1)
+	}
+	search := newSearch(config.Elastic)
+	if err := search.setup(config.Elastic, *dryRun, os.Stdout); err != nil {
+		logger.Error("error in setup", "error", err)
+		os.Exit(1)
+	}
+}
+
+// setup is idempotent, existing objects are only replaced when they differ from the desired state
+func (s *Search) setup(cfg *ConfigElastic, dryRun bool, out io.Writer) error {
+	if err := s.setupPolicy(cfg, dryRun, out); err != nil {
+		return err
+	}
+	if err := s.setupTemplate(cfg, dryRun, out); err != nil {
+		return err
+	}
+	if cfg.DataStream != "" {
+		return s.setupDataStream(cfg, dryRun, out)
+	}
+	return s.setupWriteAlias(cfg, dryRun, out)
+}
+
+// setupDataStream creates the data stream up front, it would otherwise be created by the first document
+func (s *Search) setupDataStream(cfg *ConfigElastic, dryRun bool, out io.Writer) error {
+	res, err := esapi.IndicesGetDataStreamRequest{Name: []string{cfg.DataStream}}.Do(context.Background(), s.esClient)
+	if err != nil {
+		return err
+	}
+	found, err := decodeSetupResponse(res, "error reading data stream", &map[string]any{})
+	if err != nil {
+		return err
+	}
+	if found {
+		fmt.Fprintf(out, "data stream %v: unchanged\n", cfg.DataStream)
+		return nil
+	}
+	return s.apply(out, "data stream", cfg.DataStream, false, nil, dryRun, func() (*esapi.Response, error) {
+		return esapi.IndicesCreateDataStreamRequest{Name: cfg.DataStream}.Do(context.Background(), s.esClient)
+	})
+}
+
+func (s *Search) setupPolicy(cfg *ConfigElastic, dryRun bool, out io.Writer) error {
+	name := cfg.policyName()
+	desired := cfg.desiredPolicy()
+	res, err := esapi.ILMGetLifecycleRequest{Policy: name}.Do(context.Background(), s.esClient)
+	if err != nil {
+		return err
+	}
+	var existing map[string]struct {
+		Policy map[string]any `json:"policy"`
+	}
+	found, err := decodeSetupResponse(res, "error reading ilm policy", &existing)
+	if err != nil {
+		return err
+	}
+	var changes []string
+	if found {
+		changes = diffJSON("", existing[name].Policy, desired)
+	}
+	return s.apply(out, "ilm policy", name, found, changes, dryRun, func() (*esapi.Response, error) {
+		return esapi.ILMPutLifecycleRequest{Policy: name, Body: jsonBody(map[string]any{"policy": desired})}.Do(context.Background(), s.esClient)
+	})
+}
+
+func (s *Search) setupTemplate(cfg *ConfigElastic, dryRun bool, out io.Writer) error {
+	name := cfg.templateName()
+	desired := cfg.desiredTemplate()
+	res, err := esapi.IndicesGetIndexTemplateRequest{Name: name}.Do(context.Background(), s.esClient)
+	if err != nil {
+		return err
+	}
+	var existing struct {
+		IndexTemplates []struct {
+			IndexTemplate map[string]any `json:"index_template"`
+		} `json:"index_templates"`
+	}
+	found, err := decodeSetupResponse(res, "error reading index template", &existing)
+	if err != nil {
+		return err
+	}
+	var changes []string
+	if found && len(existing.IndexTemplates) > 0 {
+		current := existing.IndexTemplates[0].IndexTemplate
+		for _, key := range []string{"index_patterns", "priority", "data_stream", "template"} {
+			changes = append(changes, diffJSON(key, current[key], desired[key])...)
+		}
+	}
+	return s.apply(out, "index template", name, found, changes, dryRun, func() (*esapi.Response, error) {
+		return esapi.IndicesPutIndexTemplateRequest{Name: name, Body: jsonBody(desired)}.Do(context.Background(), s.esClient)
+	})
+}
+
+func (s *Search) setupWriteAlias(cfg *ConfigElastic, dryRun bool, out io.Writer) error {
+	res, err := esapi.IndicesExistsAliasRequest{Name: []string{cfg.Index}}.Do(context.Background(), s.esClient)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		fmt.Fprintf(out, "write alias %v: unchanged\n", cfg.Index)
+		return nil
+	}
+	res, err = esapi.IndicesExistsRequest{Index: []string{cfg.Index}}.Do(context.Background(), s.esClient)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		fmt.Fprintf(out, "write alias %v: an index with the name exists, reindex it to use rollover\n", cfg.Index)
+		return nil
+	}
+	first := cfg.Index + "-000001"
+	body := map[string]any{"aliases": map[string]any{cfg.Index: map[string]any{"is_write_index": true}}}
+	return s.apply(out, "write alias", cfg.Index+" -> "+first, false, nil, dryRun, func() (*esapi.Response, error) {
+		return esapi.IndicesCreateRequest{Index: first, Body: jsonBody(body)}.Do(context.Background(), s.esClient)
+	})
+}
+
+// apply reports the state of one setup object and writes it when missing or different
+func (s *Search) apply(out io.Writer, kind string, name string, found bool, changes []string, dryRun bool, put func() (*esapi.Response, error)) error {
+	state := "created"
+	if found {
+		if len(changes) == 0 {
+			fmt.Fprintf(out, "%v %v: unchanged\n", kind, name)
+			return nil
+		}
+		state = "updated"
+	}
+	if dryRun {
+		state = "would be " + state
+	}
+	fmt.Fprintf(out, "%v %v: %v\n", kind, name, state)
+	for _, change := range changes {
+		fmt.Fprintf(out, "  %v\n", change)
+	}
+	if dryRun {
+		return nil
+	}
+	res, err := put()
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		printESError("error writing "+kind, res)
+		return ErrStatusNotAccepted
+	}
+	return nil
+}
+
+// decodeSetupResponse decodes a get response, returning false when the object does not exist
+func decodeSetupResponse(res *esapi.Response, message string, v any) (bool, error) {
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if res.IsError() {
+		printESError(message, res)
+		return false, ErrStatusNotAccepted
+	}
+	return true, json.NewDecoder(res.Body).Decode(v)
+}
+
+func jsonBody(v any) io.Reader {
+	byteArray, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return bytes.NewReader(byteArray)
+}
+
+// diffJSON lists the paths where existing differs from desired, values are compared after a json round trip
+func diffJSON(path string, existing any, desired any) []string {
+	existing, desired = normalizeJSON(existing), normalizeJSON(desired)
+	existingMap, existingIsMap := existing.(map[string]any)
+	desiredMap, desiredIsMap := desired.(map[string]any)
+	if !existingIsMap || !desiredIsMap {
+		if fmt.Sprint(existing) == fmt.Sprint(desired) {
+			return nil
+		}
+		return []string{fmt.Sprintf("%v: %v -> %v", path, existing, desired)}
+	}
+	keys := []string{}
+	for key := range desiredMap {
+		keys = append(keys, key)
+	}
+	for key := range existingMap {
+		if _, found := desiredMap[key]; !found {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var changes []string
+	for _, key := range keys {
+		child := key
+		if path != "" {
+			child = path + "." + key
+		}
+		changes = append(changes, diffJSON(child, existingMap[key], desiredMap[key])...)
+	}
+	return changes
+}
+
+func normalizeJSON(v any) any {
+	byteArray, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var r any
+	if err := json.Unmarshal(byteArray, &r); err != nil {
+		return v
+	}
+	return r
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v9"
+)
+
+func Test_DiffJSON(t *testing.T) {
+	existing := map[string]any{"a": "1", "b": map[string]any{"c": 2.0, "d": "old"}, "e": true}
+	desired := map[string]any{"a": "1", "b": map[string]any{"c": 2, "d": "new"}}
+	changes := diffJSON("", existing, desired)
+	if len(changes) != 2 || changes[0] != "b.d: old -> new" || changes[1] != "e: true -> <nil>" {
+		t.Errorf("unexpected changes %v", changes)
+	}
+}
+
+func Test_Setup(t *testing.T) {
+	setupTestlogging()
+	cfg := &ConfigElastic{Index: "github-events", Setup: ConfigSetup{RolloverMaxAge: "30d", RolloverMaxSize: "50gb"}}
+	policy := string(jsonBodyBytes(t, map[string]any{"github-events": map[string]any{"policy": cfg.desiredPolicy()}}))
+	writes := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPut {
+			writes = append(writes, r.URL.Path)
+			w.Write([]byte(`{"acknowledged":true}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/_ilm/policy/github-events":
+			w.Write([]byte(policy))
+		case "/_index_template/github-events":
+			w.Write([]byte(`{"index_templates":[{"name":"github-events","index_template":{"index_patterns":["github-events-*"],"priority":100}}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	search := &Search{esClient: client, index: cfg.Index}
+	out := new(bytes.Buffer)
+	if err := search.setup(cfg, true, out); err != nil {
+		t.Fatal(err)
+	}
+	if len(writes) != 0 {
+		t.Errorf("dry run should not write, got %v", writes)
+	}
+	report := out.String()
+	for _, wanted := range []string{"ilm policy github-events: unchanged", "index template github-events: would be updated", "priority: 100 -> 200", "write alias github-events -> github-events-000001: would be created"} {
+		if !strings.Contains(report, wanted) {
+			t.Errorf("report should contain %q, got\n%v", wanted, report)
+		}
+	}
+	if err := search.setup(cfg, false, new(bytes.Buffer)); err != nil {
+		t.Fatal(err)
+	}
+	if len(writes) != 2 || writes[0] != "/_index_template/github-events" || writes[1] != "/github-events-000001" {
+		t.Errorf("expected template and first index written, got %v", writes)
+	}
+}
+
+func jsonBodyBytes(t *testing.T, v any) []byte {
+	buf := new(bytes.Buffer)
+	if _, err := buf.ReadFrom(jsonBody(v)); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func Test_DataStreamTemplate(t *testing.T) {
+	cfg := &ConfigElastic{Index: "github-events", DataStream: "logs-github.pullrequest-default"}
+	template := cfg.desiredTemplate()
+	if _, found := template["data_stream"]; !found {
+		t.Errorf("data stream template should enable data_stream")
+	}
+	if patterns := template["index_patterns"].([]any); patterns[0] != "logs-github.pullrequest-default" {
+		t.Errorf("unexpected patterns %v", patterns)
+	}
+	mappings := template["template"].(map[string]any)["mappings"].(map[string]any)
+	if _, found := mappings["properties"].(map[string]any)["@timestamp"]; !found {
+		t.Errorf("data stream mappings should have @timestamp")
+	}
+	if cfg.templateName() != cfg.DataStream || cfg.policyName() != cfg.DataStream {
+		t.Errorf("names should default to the data stream")
+	}
+}
+
+func Test_DataStreamSetupUnchanged(t *testing.T) {
+	setupTestlogging()
+	cfg := &ConfigElastic{Index: "github-events", DataStream: "logs-github.pullrequest-default"}
+	existing := cfg.desiredTemplate()
+	existing["data_stream"] = map[string]any{"allow_custom_routing": false, "hidden": false}
+	template := string(jsonBodyBytes(t, map[string]any{"index_templates": []any{map[string]any{"name": cfg.DataStream, "index_template": existing}}}))
+	policy := string(jsonBodyBytes(t, map[string]any{cfg.DataStream: map[string]any{"policy": cfg.desiredPolicy()}}))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method != http.MethodGet:
+			t.Errorf("unexpected %v %v", r.Method, r.URL.Path)
+			w.Write([]byte(`{"acknowledged":true}`))
+		case r.URL.Path == "/_ilm/policy/"+cfg.DataStream:
+			w.Write([]byte(policy))
+		case r.URL.Path == "/_index_template/"+cfg.DataStream:
+			w.Write([]byte(template))
+		default:
+			w.Write([]byte(`{"data_streams":[{"name":"logs-github.pullrequest-default"}]}`))
+		}
+	}))
+	t.Cleanup(server.Close)
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	search := &Search{esClient: client, index: cfg.DataStream}
+	out := new(bytes.Buffer)
+	if err := search.setup(cfg, false, out); err != nil {
+		t.Fatal(err)
+	}
+	if wanted := "index template logs-github.pullrequest-default: unchanged"; !strings.Contains(out.String(), wanted) {
+		t.Errorf("report should contain %q, got\n%v", wanted, out.String())
+	}
+}
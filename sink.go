@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Sink is where crawled and delivered events are written
+type Sink interface {
+	// createEvent queues an event, attrs are used when logging the result
+	createEvent(eventType string, repository string, documentID string, event any, body []byte, attrs ...any) error
+	// Close flushes queued events
+	Close() error
+}
+
+// failureReporter is implemented by sinks writing documents after createEvent returned, so a document can
+// still fail once the crawler has moved on
+type failureReporter interface {
+	// failedRepositories returns and forgets the repositories with documents that failed since the last call
+	failedRepositories() []string
+}
+
+// failedRepositories collects the repositories of documents that failed after being queued
+type failedRepositories struct {
+	mu           sync.Mutex
+	repositories map[string]bool
+}
+
+func (f *failedRepositories) add(repository string) {
+	if repository == "" {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.repositories == nil {
+		f.repositories = make(map[string]bool)
+	}
+	f.repositories[repository] = true
+}
+
+func (f *failedRepositories) take() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var r []string
+	for repository := range f.repositories {
+		r = append(r, repository)
+	}
+	sort.Strings(r)
+	f.repositories = nil
+	return r
+}
+
+// initSink connects to the cluster elastic.type selects
+func initSink(config *ConfigElastic) Sink {
+	switch config.Type {
+	case "", "elasticsearch":
+		return initSearch(config)
+	case "opensearch":
+		return initOpenSearch(config)
+	}
+	logger.Error("unknown elastic.type", "type", config.Type)
+	os.Exit(1)
+	return nil
+}
+
+// initSinks connects to every configured output, events are fanned out to all of them
+func initSinks(config *ConfigType) Sink {
+	var sinks MultiSink
+	for _, output := range config.Outputs {
+		switch output {
+		case "elastic":
+			sinks = append(sinks, initSink(config.Elastic))
+		case "kafka":
+			sink, err := NewKafkaSink(config.Kafka)
+			if err == nil {
+				err = sink.check()
+			}
+			if err != nil {
+				logger.Error("error connecting to kafka", "brokers", config.Kafka.Brokers, "topic", config.Kafka.Topic, "error", err)
+				os.Exit(1)
+			}
+			sinks = append(sinks, sink)
+		case "nats":
+			sink, err := NewNATSSink(config.NATS)
+			if err != nil {
+				logger.Error("error connecting to nats", "url", config.NATS.URL, "error", err)
+				os.Exit(1)
+			}
+			sinks = append(sinks, sink)
+		default:
+			logger.Error("unknown output", "output", output)
+			os.Exit(1)
+		}
+	}
+	if len(sinks) == 0 {
+		logger.Error("no outputs configured")
+		os.Exit(1)
+	}
+	if len(sinks) == 1 {
+		return sinks[0]
+	}
+	return sinks
+}
+
+// MultiSink writes every event to all its sinks, an event failing in one sink is still written to the others
+type MultiSink []Sink
+
+func (m MultiSink) createEvent(eventType string, repository string, documentID string, event any, body []byte, attrs ...any) error {
+	var errs []error
+	for _, sink := range m {
+		errs = append(errs, sink.createEvent(eventType, repository, documentID, event, body, attrs...))
+	}
+	return errors.Join(errs...)
+}
+
+func (m MultiSink) Close() error {
+	var errs []error
+	for _, sink := range m {
+		errs = append(errs, sink.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (m MultiSink) failedRepositories() []string {
+	var r []string
+	for _, sink := range m {
+		if reporter, ok := sink.(failureReporter); ok {
+			r = append(r, reporter.failedRepositories()...)
+		}
+	}
+	return r
+}
+
+// elasticSearch finds the elasticsearch sink, if there is one
+func elasticSearch(sink Sink) *Search {
+	switch s := sink.(type) {
+	case *Search:
+		return s
+	case MultiSink:
+		for _, sink := range s {
+			if found := elasticSearch(sink); found != nil {
+				return found
+			}
+		}
+	}
+	return nil
+}
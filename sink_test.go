@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+type recordingSink struct {
+	events       []string
+	eventTypes   []string
+	repositories []string
+	values       []any
+	err          error
+	closed       bool
+	failed       []string
+}
+
+func (r *recordingSink) failedRepositories() []string {
+	failed := r.failed
+	r.failed = nil
+	return failed
+}
+
+func (r *recordingSink) createEvent(eventType string, repository string, documentID string, event any, body []byte, attrs ...any) error {
+	r.events = append(r.events, documentID)
+	r.eventTypes = append(r.eventTypes, eventType)
+	r.repositories = append(r.repositories, repository)
+	r.values = append(r.values, event)
+	return r.err
+}
+
+func (r *recordingSink) Close() error {
+	r.closed = true
+	return nil
+}
+
+func Test_MultiSink(t *testing.T) {
+	failing := &recordingSink{err: ErrNotAcknowledged}
+	working := &recordingSink{}
+	sink := MultiSink{failing, working}
+	if err := sink.createEvent("issues", "owner/repo", "id", &IssuesEvent{}, []byte(`{}`)); !errors.Is(err, ErrNotAcknowledged) {
+		t.Errorf("%v should be %v", err, ErrNotAcknowledged)
+	}
+	if len(working.events) != 1 {
+		t.Errorf("event should reach the other sinks when one fails")
+	}
+	if err := sink.Close(); err != nil || !failing.closed || !working.closed {
+		t.Errorf("all sinks should be closed, error %v", err)
+	}
+	search := &Search{}
+	if elasticSearch(MultiSink{working, search}) != search {
+		t.Errorf("elasticsearch sink should be found")
+	}
+	if elasticSearch(working) != nil {
+		t.Errorf("no elasticsearch sink expected")
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+var (
+	stream_events = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "stream_events_total",
+		Help: "Events published to kafka or nats by sink and result (acknowledged, duplicate, failed)",
+	}, []string{"sink", "result"})
+)
+
+var (
+	ErrNotAcknowledged  = errors.New("error, event not acknowledged")
+	ErrNoBrokers        = errors.New("error, kafka.brokers not configured")
+	ErrTopicUnavailable = errors.New("error, kafka topic unavailable")
+	ErrDrainTimeout     = errors.New("error, timeout draining nats connection")
+	ErrSASLMechanism    = errors.New("error, unknown kafka.sasl_mechanism")
+)
+
+type ConfigKafka struct {
+	// Brokers are host:port addresses the cluster is bootstrapped from
+	Brokers []string `mapstructure:"brokers"`
+	Topic   string   `mapstructure:"topic"`
+	// SASLMechanism is plain, scram-sha-256 or scram-sha-512, used when Username is set
+	SASLMechanism string        `mapstructure:"sasl_mechanism"`
+	Username      string        `mapstructure:"username"`
+	Password      string        `mapstructure:"password"`
+	TLS           bool          `mapstructure:"tls"`
+	Timeout       time.Duration `mapstructure:"timeout"`
+}
+
+func (c *ConfigKafka) populateEnv() {
+	envPassword := os.Getenv(BaseENVname + "_KAFKA_PASSWORD")
+	if envPassword != "" {
+		c.Password = envPassword
+	}
+}
+
+func (c *ConfigKafka) saslMechanism() (sasl.Mechanism, error) {
+	if c.Username == "" {
+		return nil, nil
+	}
+	switch c.SASLMechanism {
+	case "", "plain":
+		return plain.Mechanism{Username: c.Username, Password: c.Password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, c.Username, c.Password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, c.Username, c.Password)
+	}
+	return nil, fmt.Errorf("%w: %v", ErrSASLMechanism, c.SASLMechanism)
+}
+
+type ConfigNATS struct {
+	URL         string        `mapstructure:"url"`
+	Subject     string        `mapstructure:"subject"`
+	Credentials string        `mapstructure:"credentials"`
+	Timeout     time.Duration `mapstructure:"timeout"`
+}
+
+// KafkaSink produces events to the brokers, createEvent returns when every in sync replica has the record
+type KafkaSink struct {
+	writer  *kafka.Writer
+	client  *kafka.Client
+	topic   string
+	timeout time.Duration
+}
+
+func NewKafkaSink(config ConfigKafka) (*KafkaSink, error) {
+	if len(config.Brokers) == 0 {
+		return nil, ErrNoBrokers
+	}
+	mechanism, err := config.saslMechanism()
+	if err != nil {
+		return nil, err
+	}
+	transport := &kafka.Transport{SASL: mechanism, ClientID: "go-github-es-timed-events"}
+	if config.TLS {
+		transport.TLS = &tls.Config{}
+	}
+	addr := kafka.TCP(config.Brokers...)
+	return &KafkaSink{
+		writer: &kafka.Writer{
+			Addr:  addr,
+			Topic: config.Topic,
+			// same partition for a key as the java producer
+			Balancer:     &kafka.Murmur2Balancer{},
+			RequiredAcks: kafka.RequireAll,
+			// every event is written on its own, createEvent waits for the acknowledgement
+			BatchSize:    1,
+			WriteTimeout: config.Timeout,
+			Transport:    transport,
+		},
+		client:  &kafka.Client{Addr: addr, Transport: transport, Timeout: config.Timeout},
+		topic:   config.Topic,
+		timeout: config.Timeout,
+	}, nil
+}
+
+// check asks the brokers for the topic so a wrong address or topic is found at startup
+func (k *KafkaSink) check() error {
+	ctx, cancel := context.WithTimeout(context.Background(), k.timeout)
+	defer cancel()
+	metadata, err := k.client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{k.topic}})
+	if err != nil {
+		return err
+	}
+	if len(metadata.Topics) != 1 {
+		return ErrTopicUnavailable
+	}
+	if err := metadata.Topics[0].Error; err != nil {
+		return fmt.Errorf("%w: %w", ErrTopicUnavailable, err)
+	}
+	return nil
+}
+
+// message is keyed by repository full name so the events of a repository share a partition, the document id
+// is carried in the event_id header and the value is the event body unchanged
+func (k *KafkaSink) message(eventType string, repository string, documentID string, body []byte) kafka.Message {
+	return kafka.Message{
+		Key:   []byte(repository),
+		Value: body,
+		Headers: []kafka.Header{
+			{Key: "event_id", Value: []byte(documentID)},
+			{Key: "github_event", Value: []byte(eventType)},
+		},
+	}
+}
+
+func (k *KafkaSink) createEvent(eventType string, repository string, documentID string, event any, body []byte, attrs ...any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), k.timeout)
+	defer cancel()
+	err := k.writer.WriteMessages(ctx, k.message(eventType, repository, documentID, body))
+	if err != nil {
+		err = fmt.Errorf("%w: %w", ErrNotAcknowledged, err)
+		stream_events.WithLabelValues("kafka", "failed").Inc()
+		logger.Error("error producing event", append(attrs, "event", eventType, "documentID", documentID, "error", err)...)
+		return err
+	}
+	stream_events.WithLabelValues("kafka", "acknowledged").Inc()
+	debugLogger.Debug("Produced event", append(attrs, "event", eventType, "documentID", documentID)...)
+	return nil
+}
+
+func (k *KafkaSink) Close() error {
+	return k.writer.Close()
+}
+
+// NATSSink publishes events to a jetstream subject, the document id is the message id so the stream drops
+// redeliveries within its duplicate window
+type NATSSink struct {
+	conn    *nats.Conn
+	js      jetstream.JetStream
+	subject string
+	timeout time.Duration
+	// closed is closed once the connection is, after a drain has flushed pending publishes
+	closed chan struct{}
+}
+
+func NewNATSSink(config ConfigNATS) (*NATSSink, error) {
+	closed := make(chan struct{})
+	options := []nats.Option{
+		nats.Name("go-github-es-timed-events"),
+		nats.ClosedHandler(func(*nats.Conn) { close(closed) }),
+	}
+	if config.Credentials != "" {
+		options = append(options, nats.UserCredentials(config.Credentials))
+	}
+	conn, err := nats.Connect(config.URL, options...)
+	if err != nil {
+		return nil, err
+	}
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &NATSSink{conn: conn, js: js, subject: config.Subject, timeout: config.Timeout, closed: closed}, nil
+}
+
+// message carries the document id as Nats-Msg-Id, which jetstream deduplicates on
+func (n *NATSSink) message(eventType string, repository string, documentID string, body []byte) *nats.Msg {
+	msg := nats.NewMsg(n.subject)
+	msg.Data = body
+	msg.Header.Set(jetstream.MsgIDHeader, documentID)
+	msg.Header.Set("Github-Repository", repository)
+	msg.Header.Set("Github-Event", eventType)
+	return msg
+}
+
+func (n *NATSSink) createEvent(eventType string, repository string, documentID string, event any, body []byte, attrs ...any) error {
+	msg := n.message(eventType, repository, documentID, body)
+	ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+	defer cancel()
+	ack, err := n.js.PublishMsg(ctx, msg)
+	if err != nil {
+		stream_events.WithLabelValues("nats", "failed").Inc()
+		logger.Error("error publishing event", append(attrs, "event", eventType, "documentID", documentID, "error", err)...)
+		return err
+	}
+	if ack.Duplicate {
+		stream_events.WithLabelValues("nats", "duplicate").Inc()
+		debugLogger.Debug("Published event - Already exists", append(attrs, "event", eventType, "documentID", documentID)...)
+		return nil
+	}
+	stream_events.WithLabelValues("nats", "acknowledged").Inc()
+	debugLogger.Debug("Published event", append(attrs, "event", eventType, "documentID", documentID, "stream", ack.Stream, "sequence", ack.Sequence)...)
+	return nil
+}
+
+// Close drains the connection and waits for it to close, Drain only starts draining
+func (n *NATSSink) Close() error {
+	if err := n.conn.Drain(); err != nil {
+		return err
+	}
+	select {
+	case <-n.closed:
+		return nil
+	case <-time.After(n.timeout):
+		return ErrDrainTimeout
+	}
+}
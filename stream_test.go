@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func Test_KafkaSinkMessage(t *testing.T) {
+	setupTestlogging()
+	if _, err := NewKafkaSink(ConfigKafka{Topic: "github-events"}); err != ErrNoBrokers {
+		t.Errorf("%v should be %v", err, ErrNoBrokers)
+	}
+	if _, err := NewKafkaSink(ConfigKafka{Brokers: []string{"localhost:9092"}, Username: "github-hook", SASLMechanism: "gssapi"}); !errors.Is(err, ErrSASLMechanism) {
+		t.Errorf("%v should be %v", err, ErrSASLMechanism)
+	}
+	sink, err := NewKafkaSink(ConfigKafka{Brokers: []string{"localhost:9092"}, Topic: "github-events", Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	message := sink.message("pull_request", "owner/repo", "72d3162e-cc78-11e3-81ab-4c9367dc0958", []byte(`{"action":"opened"}`))
+	if string(message.Key) != "owner/repo" {
+		t.Errorf("records should be keyed by repository, got %v", string(message.Key))
+	}
+	if string(message.Value) != `{"action":"opened"}` {
+		t.Errorf("unexpected value %v", string(message.Value))
+	}
+	headers := map[string]string{}
+	for _, header := range message.Headers {
+		headers[header.Key] = string(header.Value)
+	}
+	if headers["event_id"] != "72d3162e-cc78-11e3-81ab-4c9367dc0958" || headers["github_event"] != "pull_request" {
+		t.Errorf("unexpected headers %v", headers)
+	}
+}
+
+func Test_KafkaSinkNotAcknowledged(t *testing.T) {
+	setupTestlogging()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	sink, err := NewKafkaSink(ConfigKafka{Brokers: []string{address}, Topic: "github-events", Timeout: 500 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if err := sink.check(); err == nil {
+		t.Error("check should fail without a broker")
+	}
+	if err := sink.createEvent("pull_request", "owner/repo", "rejected", &PullRequestEvent{}, []byte(`{}`)); !errors.Is(err, ErrNotAcknowledged) {
+		t.Errorf("%v should be %v", err, ErrNotAcknowledged)
+	}
+}
+
+func Test_NATSSinkMessage(t *testing.T) {
+	sink := &NATSSink{subject: "github.events"}
+	msg := sink.message("workflow_run", "owner/repo", "document-id", []byte(`{}`))
+	if msg.Subject != "github.events" || string(msg.Data) != `{}` {
+		t.Errorf("unexpected message %v %v", msg.Subject, string(msg.Data))
+	}
+	if msg.Header.Get(jetstream.MsgIDHeader) != "document-id" {
+		t.Errorf("message id should be the document id, got %v", msg.Header.Get(jetstream.MsgIDHeader))
+	}
+	if msg.Header.Get("Github-Repository") != "owner/repo" || msg.Header.Get("Github-Event") != "workflow_run" {
+		t.Errorf("unexpected headers %v", msg.Header)
+	}
+}
+
+// newTestNATSServer accepts nats clients, answering PING with PONG and ignoring everything else
+func newTestNATSServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.Write([]byte(`INFO {"server_id":"test","version":"2.10.0","proto":1,"headers":true,"max_payload":1048576}` + "\r\n"))
+				reader := bufio.NewReader(conn)
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if strings.HasPrefix(line, "PING") {
+						conn.Write([]byte("PONG\r\n"))
+					}
+				}
+			}()
+		}
+	}()
+	return "nats://" + listener.Addr().String()
+}
+
+func Test_NATSSinkCloseWaitsForDrain(t *testing.T) {
+	setupTestlogging()
+	sink, err := NewNATSSink(ConfigNATS{URL: newTestNATSServer(t), Subject: "github.events", Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !sink.conn.IsClosed() {
+		t.Errorf("connection should be closed when Close returns")
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// stringList is a flag that can be given more than once
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+type uninstallSummary struct {
+	Targets int
+	Matched int
+	Deleted int
+	Failed  int
+}
+
+// runUninstallWebHooks is the uninstall-webhooks command, removing hooks pointing at this service
+func runUninstallWebHooks(args []string) {
+	flags := flag.NewFlagSet("uninstall-webhooks", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "List the hooks that would be deleted without deleting them")
+	var urls stringList
+	flags.Var(&urls, "url", "Hook url to delete instead of the configured one, can be given more than once")
+	flags.Parse(args)
+	if len(urls) == 0 {
+		urls = append(urls, config.Github.getWebHookURL())
+	}
+	crawler := &Crawler{Config: config.Github}
+	summary, err := crawler.uninstallWebHooks(urls, *dryRun, os.Stdout)
+	fmt.Printf("targets: %v, matched: %v, deleted: %v, failed: %v\n", summary.Targets, summary.Matched, summary.Deleted, summary.Failed)
+	if err != nil {
+		logger.Error("error uninstalling webhooks", "error", err)
+		os.Exit(1)
+	}
+	if summary.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// uninstallWebHooks deletes hooks with one of the urls from every visible repository and the configured organizations
+func (c *Crawler) uninstallWebHooks(urls []string, dryRun bool, out io.Writer) (uninstallSummary, error) {
+	summary := uninstallSummary{}
+	repositories, err := c.ListRepositories()
+	if err != nil {
+		return summary, err
+	}
+	targets := map[string]string{}
+	names := []string{}
+	for _, org := range c.Config.Webhook.Organizations {
+		targets[org] = c.client().url("/orgs/%v/hooks", org)
+		names = append(names, org)
+	}
+	for _, repository := range repositories {
+		targets[repository.FullName] = c.client().url("/repos/%v/hooks", repository.FullName)
+		names = append(names, repository.FullName)
+	}
+	for _, name := range names {
+		summary.Targets += 1
+		webhooks, err := c.listWebHooks(targets[name])
+		if errors.Is(err, ErrStatusForbidden) || errors.Is(err, ErrStatusNotFound) {
+			// hooks can only be listed with admin access, which is expected to be missing on some repositories
+			debugLogger.Debug("unable to list webhooks", "target", name, "error", err)
+			continue
+		}
+		if err != nil {
+			summary.Failed += 1
+			fmt.Fprintf(out, "failed to list %v hooks: %v\n", name, err)
+			continue
+		}
+		for _, webhook := range webhooks {
+			if !slices.Contains(urls, webhook.Config.URL) {
+				continue
+			}
+			summary.Matched += 1
+			if dryRun {
+				fmt.Fprintf(out, "would delete %v hook %v %v\n", name, *webhook.ID, webhook.Config.URL)
+				continue
+			}
+			_, err := c.client().do("DELETE", fmt.Sprintf("%v/%v", targets[name], *webhook.ID), nil, nil)
+			if err != nil {
+				summary.Failed += 1
+				fmt.Fprintf(out, "failed to delete %v hook %v: %v\n", name, *webhook.ID, err)
+				continue
+			}
+			summary.Deleted += 1
+			fmt.Fprintf(out, "deleted %v hook %v %v\n", name, *webhook.ID, webhook.Config.URL)
+		}
+	}
+	return summary, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func Test_UninstallWebHooks(t *testing.T) {
+	setupTestlogging()
+	deleted := []string{}
+	server := newTestGithubServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/user/repos":
+			fmt.Fprintf(w, `[{"id":1,"full_name":"owner/one"},{"id":2,"full_name":"owner/private"},{"id":3,"full_name":"owner/broken"}]`)
+		case r.URL.Path == "/repos/owner/one/hooks":
+			fmt.Fprintf(w, `[{"id":1,"config":{"url":"http://old/webhook"}},{"id":2,"config":{"url":"http://other/webhook"}}]`)
+		case r.URL.Path == "/repos/owner/private/hooks":
+			w.WriteHeader(http.StatusNotFound)
+		case r.URL.Path == "/repos/owner/broken/hooks":
+			w.WriteHeader(http.StatusInternalServerError)
+		case r.Method == http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			t.Errorf("unexpected %v %v", r.Method, r.URL)
+		}
+	})
+	c := &Crawler{Config: ConfigGithub{APIURL: server.URL}}
+	out := new(bytes.Buffer)
+	summary, err := c.uninstallWebHooks([]string{"http://old/webhook"}, true, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Matched != 1 || summary.Deleted != 0 || len(deleted) != 0 {
+		t.Errorf("dry run should only match, got %+v %v", summary, deleted)
+	}
+	summary, err = c.uninstallWebHooks([]string{"http://old/webhook"}, false, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Failed != 1 {
+		t.Errorf("only the listing that did not fail with 403 or 404 should count as failed, got %+v", summary)
+	}
+	if summary.Targets != 3 || summary.Deleted != 1 || len(deleted) != 1 || deleted[0] != "/repos/owner/one/hooks/1" {
+		t.Errorf("expected owner/one hook 1 deleted, got %+v %v", summary, deleted)
+	}
+}
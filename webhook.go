@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	// GitHub caps webhook payloads at 25MB
+	maxWebhookPayload = 25 << 20
+	signaturePrefix   = "sha256="
+)
+
+var (
+	ErrSignatureMissing = errors.New("error, signature missing")
+	ErrSignatureInvalid = errors.New("error, signature invalid")
+)
+
+var (
+	github_webhook_previous_secret = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "github_webhook_previous_secret",
+		Help: "Repositories whose last delivery was signed with the previous secret",
+	}, []string{"repository"})
+)
+
+// WebhookHandler receives deliveries, during a secret rotation deliveries signed with PreviousSecret are
+// accepted until PreviousExpires
+type WebhookHandler struct {
+	Secret          string
+	PreviousSecret  string
+	PreviousExpires time.Time
+	Sink            Sink
+	Rotation        *SecretRotation
+}
+
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	bodyText, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookPayload))
+	if err != nil {
+		logger.Error("error reading body", "error", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	signature := r.Header.Get("X-Hub-Signature-256")
+	err = h.verifySignature(signature, bodyText)
+	if err == ErrSignatureInvalid && h.acceptPrevious() {
+		err = verifySignature(h.PreviousSecret, signature, bodyText)
+		if err == nil {
+			repository := deliveryRepository(bodyText)
+			logger.Warn("webhook signed with previous secret", "repository", repository, "delivery", r.Header.Get("X-GitHub-Delivery"))
+			h.Rotation.markPrevious(repository)
+		}
+	} else if err == nil && h.Secret != "" {
+		h.Rotation.markCurrent(deliveryRepository(bodyText))
+	}
+	if err != nil {
+		logger.Info("webhook rejected", "remote", r.RemoteAddr, "error", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	eventType := r.Header.Get("X-GitHub-Event")
+	delivery := r.Header.Get("X-GitHub-Delivery")
+	debugLogger.Debug("webhook received", "event", eventType, "delivery", delivery)
+	var event webhookEvent
+	switch eventType {
+	case "ping":
+		w.WriteHeader(http.StatusOK)
+		return
+	case "pull_request":
+		event = new(PullRequestEvent)
+	case "issues":
+		event = new(IssuesEvent)
+	case "pull_request_review":
+		event = new(PullRequestReviewEvent)
+	case "workflow_run":
+		event = new(WorkflowRunEvent)
+	case "workflow_job":
+		event = new(WorkflowJobEvent)
+	default:
+		debugLogger.Debug("webhook event ignored", "event", eventType, "delivery", delivery)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if err := json.Unmarshal(bodyText, event); err != nil {
+		logger.Error("unable to unmarshal body", "event", eventType, "delivery", delivery, "error", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	h.index(w, eventType, delivery, deliveryRepository(bodyText), event)
+}
+
+type webhookEvent interface {
+	parse() ([]byte, error)
+}
+
+// index stores a webhook event using the delivery GUID as document id so redeliveries are not duplicated
+func (h *WebhookHandler) index(w http.ResponseWriter, eventType string, delivery string, repository string, event webhookEvent) {
+	documentID := delivery
+	if documentID == "" {
+		documentID = uuid.New().String()
+	}
+	byteArray, err := event.parse()
+	if err != nil {
+		logger.Error("error parsing payload to json", "event", eventType, "delivery", delivery, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	err = h.Sink.createEvent(eventType, repository, documentID, event, byteArray, "event", eventType, "delivery", delivery)
+	if err != nil {
+		logger.Error("error queuing document", "event", eventType, "delivery", delivery, "error", err)
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	debugLogger.Debug("Queued webhook", "event", eventType, "delivery", delivery, "documentID", documentID)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *WebhookHandler) verifySignature(signature string, body []byte) error {
+	if h.Secret == "" {
+		return nil
+	}
+	return verifySignature(h.Secret, signature, body)
+}
+
+func (h *WebhookHandler) acceptPrevious() bool {
+	if h.PreviousSecret == "" {
+		return false
+	}
+	return h.PreviousExpires.IsZero() || time.Now().Before(h.PreviousExpires)
+}
+
+func verifySignature(secret string, signature string, body []byte) error {
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return ErrSignatureMissing
+	}
+	given, err := hex.DecodeString(strings.TrimPrefix(signature, signaturePrefix))
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+	if !hmac.Equal(given, signPayload(secret, body)) {
+		return ErrSignatureInvalid
+	}
+	return nil
+}
+
+func signPayload(secret string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// deliveryRepository is the repository, or organization for org hooks, a delivery was sent for
+func deliveryRepository(body []byte) string {
+	var delivery struct {
+		Repository *struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+		Organization *struct {
+			Login string `json:"login"`
+		} `json:"organization"`
+	}
+	if err := json.Unmarshal(body, &delivery); err != nil {
+		return ""
+	}
+	if delivery.Repository != nil {
+		return delivery.Repository.FullName
+	}
+	if delivery.Organization != nil {
+		return delivery.Organization.Login
+	}
+	return ""
+}
+
+// SecretRotation remembers which repositories still deliver with the previous secret
+type SecretRotation struct {
+	mu           sync.Mutex
+	repositories map[string]time.Time
+}
+
+func NewSecretRotation() *SecretRotation {
+	return &SecretRotation{repositories: make(map[string]time.Time)}
+}
+
+func (s *SecretRotation) markPrevious(repository string) {
+	if s == nil || repository == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.repositories[repository] = time.Now()
+	github_webhook_previous_secret.WithLabelValues(repository).Set(1)
+}
+
+func (s *SecretRotation) markCurrent(repository string) {
+	if s == nil || repository == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name := range s.repositories {
+		if rotationTarget(name, repository) {
+			delete(s.repositories, name)
+			github_webhook_previous_secret.DeleteLabelValues(name)
+		}
+	}
+}
+
+// usesPrevious tells if a repository, or any repository of an organization, still delivers with the previous secret
+func (s *SecretRotation) usesPrevious(target string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name := range s.repositories {
+		if rotationTarget(name, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func rotationTarget(repository string, target string) bool {
+	return repository == target || strings.HasPrefix(repository, target+"/")
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_VerifySignature(t *testing.T) {
+	setupTestlogging()
+	body := []byte(`{"zen":"Keep it logically awesome."}`)
+	h := &WebhookHandler{Secret: "It's a Secret to Everybody"}
+	signature := signaturePrefix + hex.EncodeToString(signPayload(h.Secret, body))
+	if err := h.verifySignature(signature, body); err != nil {
+		t.Errorf("valid signature rejected: %v", err)
+	}
+	if err := h.verifySignature(signature, []byte(`{}`)); err != ErrSignatureInvalid {
+		t.Errorf("tampered body should be %v, got %v", ErrSignatureInvalid, err)
+	}
+	if err := h.verifySignature("", body); err != ErrSignatureMissing {
+		t.Errorf("missing signature should be %v, got %v", ErrSignatureMissing, err)
+	}
+	if err := h.verifySignature(signaturePrefix+"zz", body); err != ErrSignatureInvalid {
+		t.Errorf("malformed signature should be %v, got %v", ErrSignatureInvalid, err)
+	}
+}
+
+func Test_WebhookHandler(t *testing.T) {
+	setupTestlogging()
+	h := &WebhookHandler{Secret: "secret"}
+	body := `{"zen":"Keep it logically awesome."}`
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+	req.Header.Set("X-GitHub-Event", "ping")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("unsigned request should be %v, got %v", http.StatusUnauthorized, rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+	req.Header.Set("X-GitHub-Event", "ping")
+	req.Header.Set("X-Hub-Signature-256", signaturePrefix+hex.EncodeToString(signPayload(h.Secret, []byte(body))))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("signed ping should be %v, got %v", http.StatusOK, rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/webhook", nil)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET should be %v, got %v", http.StatusMethodNotAllowed, rec.Code)
+	}
+}
+
+func Test_WebhookPullRequestDelivery(t *testing.T) {
+	setupTestlogging()
+	sink := &recordingSink{}
+	h := &WebhookHandler{Secret: "secret", Sink: sink}
+	body := `{"action":"opened","number":7,"pull_request":{"id":1,"number":7,"state":"open","title":"Add feature"},"repository":{"full_name":"owner/repo"}}`
+	deliver := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+		req.Header.Set("X-GitHub-Event", "pull_request")
+		req.Header.Set("X-GitHub-Delivery", "72d3162e-cc78-11e3-81ab-4c9367dc0958")
+		req.Header.Set("X-Hub-Signature-256", signaturePrefix+hex.EncodeToString(signPayload(h.Secret, []byte(body))))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := deliver(); code != http.StatusAccepted {
+		t.Fatalf("signed pull_request should be %v, got %v", http.StatusAccepted, code)
+	}
+	if len(sink.events) != 1 || sink.events[0] != "72d3162e-cc78-11e3-81ab-4c9367dc0958" {
+		t.Fatalf("delivery guid should be the document id, got %v", sink.events)
+	}
+	if sink.eventTypes[0] != "pull_request" || sink.repositories[0] != "owner/repo" {
+		t.Errorf("unexpected event %v for %v", sink.eventTypes[0], sink.repositories[0])
+	}
+	event, ok := sink.values[0].(*PullRequestEvent)
+	if !ok {
+		t.Fatalf("event should be a PullRequestEvent, got %T", sink.values[0])
+	}
+	if event.Action != "opened" || event.PullRequest == nil || event.PullRequest.Title != "Add feature" {
+		t.Errorf("unexpected event %+v", event)
+	}
+	sink.err = ErrQueueFull
+	if code := deliver(); code != http.StatusServiceUnavailable {
+		t.Errorf("refused event should be %v, got %v", http.StatusServiceUnavailable, code)
+	}
+}
+
+func Test_WebhookPreviousSecret(t *testing.T) {
+	setupTestlogging()
+	h := &WebhookHandler{Secret: "new", PreviousSecret: "old", Rotation: NewSecretRotation()}
+	body := `{"zen":"Keep it logically awesome.","repository":{"full_name":"owner/repo"}}`
+	deliver := func(secret string) int {
+		req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+		req.Header.Set("X-GitHub-Event", "ping")
+		req.Header.Set("X-Hub-Signature-256", signaturePrefix+hex.EncodeToString(signPayload(secret, []byte(body))))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := deliver("old"); code != http.StatusOK {
+		t.Errorf("previous secret should be accepted, got %v", code)
+	}
+	if !h.Rotation.usesPrevious("owner/repo") || !h.Rotation.usesPrevious("owner") {
+		t.Errorf("owner/repo should be using the previous secret")
+	}
+	if code := deliver("new"); code != http.StatusOK {
+		t.Errorf("current secret should be accepted, got %v", code)
+	}
+	if h.Rotation.usesPrevious("owner/repo") {
+		t.Errorf("owner/repo should no longer be using the previous secret")
+	}
+	h.PreviousExpires = time.Now().Add(-time.Minute)
+	if code := deliver("old"); code != http.StatusUnauthorized {
+		t.Errorf("expired previous secret should be %v, got %v", http.StatusUnauthorized, code)
+	}
+}